@@ -1,6 +1,40 @@
 package api
 
+import "context"
+
 type API interface {
-	// Get returns an image URL
-	Get() (string, error)
+	// Name returns the name of the image source
+	Name() string
+	// Get returns an image
+	Get(ctx context.Context) (Image, error)
+}
+
+// URLer is implemented by providers that can tell the URL of the API request they
+// make, without making it
+type URLer interface {
+	// RequestURL returns the URL of the API request
+	RequestURL(ctx context.Context) (string, error)
+}
+
+// Image is an image resolved by a provider
+type Image struct {
+	// URL is where the image is downloaded from
+	URL string
+	// SHA256 is the hex encoded SHA-256 hash of the image content, if the
+	// provider supplies one
+	SHA256 string
+	// Title is the title of the image
+	Title string
+	// Copyright is the attribution of the image
+	Copyright string
+	// Description describes what the image shows
+	Description string
+	// Width and Height are the dimensions of the image in pixels, or 0 if the
+	// provider doesn't supply them
+	Width, Height int
+	// FocusX and FocusY locate the subject of the image, as fractions of its
+	// width and height from the top left corner, if HasFocus is set
+	FocusX, FocusY float64
+	// HasFocus reports whether the provider supplied a focal point
+	HasFocus bool
 }
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+)
+
+// cachedResponse is an API response body stored along with its ETag
+type cachedResponse struct {
+	ETag string `json:"etag"`
+	Body []byte `json:"body"`
+}
+
+// cachePath returns the path of the cache file for the response to rawURL
+func (r *requester) cachePath(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return path.Join(r.opts.CacheDir, hex.EncodeToString(sum[:])+".json")
+}
+
+// cached returns the cached response to rawURL, or nil if there is none or the
+// cache is disabled
+func (r *requester) cached(rawURL string) *cachedResponse {
+	if r.opts.CacheDir == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(r.cachePath(rawURL))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			r.log.Warn("failed to read cached api response", "error", err)
+		}
+		return nil
+	}
+
+	var cached cachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil || cached.ETag == "" {
+		r.log.Warn("ignoring invalid cached api response", "error", err)
+		return nil
+	}
+
+	return &cached
+}
+
+// cache reads the body of res, stores it for rawURL if res carries an ETag and
+// replaces the body of res so that it can be read again. Failing to store the
+// response is logged, as the response itself is still usable
+func (r *requester) cache(rawURL string, res *http.Response) error {
+	etag := res.Header.Get("ETag")
+	if r.opts.CacheDir == "" || etag == "" {
+		return nil
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return fmt.Errorf("read response body: %w", err)
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err := r.writeCache(rawURL, cachedResponse{ETag: etag, Body: body}); err != nil {
+		r.log.Warn("failed to cache api response", "error", err)
+		return nil
+	}
+
+	r.log.Debug("cached api response", "etag", etag)
+
+	return nil
+}
+
+// writeCache stores cached as the response to rawURL
+func (r *requester) writeCache(rawURL string, cached cachedResponse) error {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return fmt.Errorf("encode response: %w", err)
+	}
+
+	if err := os.MkdirAll(r.opts.CacheDir, 0o755); err != nil {
+		return fmt.Errorf("create cache dir: %w", err)
+	}
+
+	if err := os.WriteFile(r.cachePath(rawURL), data, 0o644); err != nil {
+		return fmt.Errorf("write cache file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultCountryURL is the default geo-IP endpoint for looking up the country.
+// It responds with the country code of the client as plain text
+const DefaultCountryURL = "https://ipinfo.io/country"
+
+// countryFromIP looks up the country code of the public IP address of the client
+// at the geo-IP endpoint countryURL, which must respond with the code as plain text
+func (r *requester) countryFromIP(ctx context.Context, countryURL string) (string, error) {
+	if err := r.checkScheme(countryURL); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, countryURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+
+	client := r.opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("received non-ok response code: %d", res.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 64))
+	if err != nil {
+		return "", fmt.Errorf("read response: %w", err)
+	}
+
+	country := strings.ToUpper(strings.TrimSpace(string(body)))
+	if len(country) != 2 || strings.IndexFunc(country, func(r rune) bool { return r < 'A' || r > 'Z' }) != -1 {
+		return "", fmt.Errorf("response is not a country code: %q", country)
+	}
+
+	return country, nil
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// localeVars are the environment variables consulted for the locale, in order of
+// precedence
+var localeVars = []string{"LC_ALL", "LC_MESSAGES", "LANG", "LANGUAGE"}
+
+// LocaleVars returns the environment variables consulted for the locale, in order
+// of precedence
+func LocaleVars() []string {
+	return slices.Clone(localeVars)
+}
+
+// Locale is the localization images are requested for
+type Locale struct {
+	// Tag is the language tag, e.g. en-US
+	Tag string
+	// Country is the country code, e.g. US
+	Country string
+}
+
+// DefaultLocale is a known-good locale, for use instead of deriving the locale. It
+// is also the locale providers fall back to if none can be derived
+var DefaultLocale = Locale{Tag: "en-US", Country: "US"}
+
+// Market returns the locale as a single market string, e.g. en-US
+func (l Locale) Market() string {
+	return l.Tag
+}
+
+// lookupLocale returns the name and value of the first locale environment variable
+// that is set to something other than the C/POSIX locale. If none is, both are
+// empty.
+func lookupLocale() (string, string) {
+	for _, name := range localeVars {
+		value := os.Getenv(name)
+		if name == "LANGUAGE" {
+			// LANGUAGE is a colon separated list of preferred languages
+			value, _, _ = strings.Cut(value, ":")
+		}
+
+		switch base, _, _ := strings.Cut(value, "."); base {
+		case "", "C", "POSIX":
+			continue
+		}
+
+		return name, value
+	}
+
+	return "", ""
+}
+
+// ResolveLocale derives the locale from the environment
+func ResolveLocale(log *slog.Logger) (Locale, error) {
+	name, lang := lookupLocale()
+	if name == "" {
+		return Locale{}, fmt.Errorf("failed to determine locale, none of %s are set", strings.Join(localeVars, ", "))
+	}
+
+	log.Debug("read locale variable", "name", name, "value", lang)
+
+	tag := ""
+	if l := strings.FieldsFunc(lang, func(r rune) bool { return r == '.' || r == '@' }); len(l) != 0 {
+		tag = strings.ReplaceAll(l[0], "_", "-")
+	} else {
+		return Locale{}, fmt.Errorf("failed to parse locale from %s: %s", name, lang)
+	}
+
+	country := ""
+	if c := strings.Split(tag, "-"); len(c) != 0 {
+		country = c[len(c)-1]
+	} else {
+		return Locale{}, fmt.Errorf("failed to parse country code from locale: %s", tag)
+	}
+
+	log.Debug("determined localization", "locale", tag, "country", country)
+
+	return Locale{Tag: tag, Country: country}, nil
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const lockscreenUrl = "https://arc.msn.com/v3/Delivery/Placement?pid=338387&fmt=json&ua=WindowsShellClient%2F0&cdm=1&disphorzres=9999&dispvertres=9999&lo=80217&pl={locale}&lc={locale}&ctry={country}"
+
+type lockscreen struct {
+	log *slog.Logger
+	req *requester
+}
+
+// NewLockscreen returns the provider for the Windows Spotlight lock screen
+// placement, which rotates through a different set of images than the desktop
+// placement of the microsoft provider
+func NewLockscreen(log *slog.Logger, opts Options) API {
+	return &lockscreen{
+		log: log,
+		req: &requester{log: log, name: "lockscreen", opts: opts, fallback: &DefaultLocale},
+	}
+}
+
+// lockscreenBody is the content of the parsed response body
+type lockscreenBody struct {
+	Batchrsp struct {
+		Items []struct {
+			Item string
+		}
+	}
+}
+
+// lockscreenAsset is an image asset of a lock screen item
+type lockscreenAsset struct {
+	U      string
+	W      string
+	H      string
+	Sha256 string
+}
+
+// lockscreenText is a text field of a lock screen item
+type lockscreenText struct {
+	Tx string
+}
+
+// lockscreenMetadata is the metadata of a lock screen item. Besides the images,
+// items carry a title, copyright and hotspots describing parts of the image. The
+// position of the first hotspot, in percent of the image dimensions, marks the
+// subject of the image
+type lockscreenMetadata struct {
+	Ad struct {
+		Landscape   lockscreenAsset `json:"image_fullscreen_001_landscape"`
+		Title       lockscreenText  `json:"title_text"`
+		Copyright   lockscreenText  `json:"copyright_text"`
+		Description lockscreenText  `json:"hs1_title_text"`
+		HotspotX    lockscreenText  `json:"hs1_x"`
+		HotspotY    lockscreenText  `json:"hs1_y"`
+	}
+}
+
+// focus returns the position of the first hotspot as fractions of the image
+// dimensions, and whether the item has a valid hotspot position
+func (m lockscreenMetadata) focus() (float64, float64, bool) {
+	x, xerr := strconv.ParseFloat(strings.TrimSuffix(m.Ad.HotspotX.Tx, "%"), 64)
+	y, yerr := strconv.ParseFloat(strings.TrimSuffix(m.Ad.HotspotY.Tx, "%"), 64)
+	if xerr != nil || yerr != nil || x < 0 || x > 100 || y < 0 || y > 100 {
+		return 0, 0, false
+	}
+
+	return x / 100, y / 100, true
+}
+
+func (api *lockscreen) Name() string {
+	return "lockscreen"
+}
+
+func (api *lockscreen) RequestURL(ctx context.Context) (string, error) {
+	return api.req.requestURL(ctx, lockscreenUrl)
+}
+
+func (api *lockscreen) Get(ctx context.Context) (Image, error) {
+	url, err := api.req.expandURL(ctx, lockscreenUrl)
+	if err != nil {
+		return Image{}, err
+	}
+
+	res, err := api.req.get(ctx, url)
+	if err != nil {
+		return Image{}, fmt.Errorf("invalid response when querying lockscreen api: %w", err)
+	}
+	defer res.Body.Close()
+
+	api.log.Debug("received api response")
+
+	if res.StatusCode != http.StatusOK {
+		return Image{}, fmt.Errorf("received non-ok response code when querying lockscreen api: %d", res.StatusCode)
+	}
+
+	var body lockscreenBody
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return Image{}, fmt.Errorf("decode lockscreen api response body: %w", err)
+	}
+
+	// the response may contain several items, the first one with a landscape image
+	// is used
+	for _, item := range body.Batchrsp.Items {
+		api.log.Debug("decoded image metadata", "value", item.Item)
+
+		var metadata lockscreenMetadata
+		if err := json.NewDecoder(strings.NewReader(item.Item)).Decode(&metadata); err != nil {
+			return Image{}, fmt.Errorf("decode lockscreen api image metadata: %w", err)
+		}
+
+		asset := metadata.Ad.Landscape
+		if asset.U == "" {
+			continue
+		}
+
+		width, _ := strconv.Atoi(asset.W)
+		height, _ := strconv.Atoi(asset.H)
+		focusX, focusY, hasFocus := metadata.focus()
+
+		return Image{
+			URL:         asset.U,
+			SHA256:      hexHash(asset.Sha256),
+			Title:       metadata.Ad.Title.Tx,
+			Copyright:   metadata.Ad.Copyright.Tx,
+			Description: metadata.Ad.Description.Tx,
+			Width:       width,
+			Height:      height,
+			FocusX:      focusX,
+			FocusY:      focusY,
+			HasFocus:    hasFocus,
+		}, nil
+	}
+
+	return Image{}, fmt.Errorf("lockscreen api response body contains no images")
+}
+
+// hexHash converts a base64 encoded SHA-256 hash, as supplied by the lock screen
+// placement, to hex. Hashes that can't be decoded are dropped, so that the image
+// is not verified rather than rejected
+func hexHash(b64 string) string {
+	sum, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil || len(sum) != 32 {
+		return ""
+	}
+
+	return hex.EncodeToString(sum)
+}
@@ -1,22 +1,26 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
-	"os"
 	"strings"
 )
 
-const apiUrl = "https://fd.api.iris.microsoft.com/v4/api/selection?&placement=88000820&bcnt=1&country=%s&locale=%s&fmt=json"
+const apiUrl = "https://fd.api.iris.microsoft.com/v4/api/selection?&placement=88000820&bcnt=1&country={country}&locale={locale}&fmt=json"
 
 type microsoft struct {
 	log *slog.Logger
+	req *requester
 }
 
-func NewMicrosoft(log *slog.Logger) API {
-	return &microsoft{log: log}
+func NewMicrosoft(log *slog.Logger, opts Options) API {
+	return &microsoft{
+		log: log,
+		req: &requester{log: log, name: "microsoft", opts: opts, fallback: &DefaultLocale},
+	}
 }
 
 // body is the content of the parsed response body
@@ -34,53 +38,45 @@ type metadata struct {
 		LandscapeImage struct {
 			Asset string
 		}
+		Title       string
+		Description string
+		Copyright   string
 	}
 }
 
-func (api *microsoft) Get() (string, error) {
-	lang := os.Getenv("LANG")
-
-	api.log.Debug("read LANG variable", "value", lang)
+func (api *microsoft) Name() string {
+	return "microsoft"
+}
 
-	locale := ""
-	if l := strings.Split(lang, "."); len(l) != 0 {
-		locale = strings.ReplaceAll(l[0], "_", "-")
-	} else {
-		return "", fmt.Errorf("failed to parse locale from LANG: %s", l)
-	}
+func (api *microsoft) RequestURL(ctx context.Context) (string, error) {
+	return api.req.requestURL(ctx, apiUrl)
+}
 
-	country := ""
-	if c := strings.Split(locale, "-"); len(c) != 0 {
-		country = c[len(c)-1]
-	} else {
-		return "", fmt.Errorf("failed to parse country code from locale: %s", locale)
+func (api *microsoft) Get(ctx context.Context) (Image, error) {
+	url, err := api.req.expandURL(ctx, apiUrl)
+	if err != nil {
+		return Image{}, err
 	}
 
-	api.log.Debug("determined localization", "locale", locale, "country", country)
-
-	url := fmt.Sprintf(apiUrl, country, locale)
-
-	api.log.Debug("calling api", "url", url)
-
-	res, err := http.Get(url)
+	res, err := api.req.get(ctx, url)
 	if err != nil {
-		return "", fmt.Errorf("invalid response when querying microsoft api: %w", err)
+		return Image{}, fmt.Errorf("invalid response when querying microsoft api: %w", err)
 	}
 	defer res.Body.Close()
 
 	api.log.Debug("received api response")
 
 	if res.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("received non-ok response code when querying microsoft api: %d", res.StatusCode)
+		return Image{}, fmt.Errorf("received non-ok response code when querying microsoft api: %d", res.StatusCode)
 	}
 
 	var body body
 	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
-		return "", fmt.Errorf("decode microsoft api response body: %w", err)
+		return Image{}, fmt.Errorf("decode microsoft api response body: %w", err)
 	}
 
 	if len(body.Batchrsp.Items) == 0 {
-		return "", fmt.Errorf("microsoft api response body contains no images")
+		return Image{}, fmt.Errorf("microsoft api response body contains no images")
 	}
 
 	item := body.Batchrsp.Items[0].Item
@@ -89,8 +85,13 @@ func (api *microsoft) Get() (string, error) {
 
 	var metadata metadata
 	if err := json.NewDecoder(strings.NewReader(item)).Decode(&metadata); err != nil {
-		return "", fmt.Errorf("decode microsoft api image metadata: %w", err)
+		return Image{}, fmt.Errorf("decode microsoft api image metadata: %w", err)
 	}
 
-	return metadata.Ad.LandscapeImage.Asset, nil
+	return Image{
+		URL:         metadata.Ad.LandscapeImage.Asset,
+		Title:       metadata.Ad.Title,
+		Copyright:   metadata.Ad.Copyright,
+		Description: metadata.Ad.Description,
+	}, nil
 }
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// providers maps source names to the constructors of their providers
+var providers = map[string]func(*slog.Logger, Options) API{
+	"microsoft":  NewMicrosoft,
+	"lockscreen": NewLockscreen,
+	"urllist":    NewURLList,
+}
+
+// msnHosts are the hosts the microsoft and lockscreen providers serve images from
+var msnHosts = []string{
+	"img-prod-cms-rt-microsoft-com.akamaized.net",
+	"img-s-msn-com.akamaized.net",
+	"microsoft.com",
+	"msn.com",
+	"bing.com",
+	"bing.net",
+}
+
+// hosts maps source names to functions returning the hosts their images are
+// served from
+var hosts = map[string]func(Options) []string{
+	"microsoft":  func(Options) []string { return slices.Clone(msnHosts) },
+	"lockscreen": func(Options) []string { return slices.Clone(msnHosts) },
+	"urllist":    urlListHosts,
+}
+
+// Hosts returns the hosts the source with the given name serves images from, as
+// far as known. For the urllist source, these are the hosts of the listed URLs
+func Hosts(name string, opts Options) []string {
+	if hosts, ok := hosts[name]; ok {
+		return hosts(opts)
+	}
+
+	return nil
+}
+
+// Sources returns the names of all available sources, sorted
+func Sources() []string {
+	return slices.Sorted(maps.Keys(providers))
+}
+
+// New returns the provider for the source with the given name
+func New(name string, log *slog.Logger, opts Options) (API, error) {
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown source %q, expected one of %s", name, strings.Join(Sources(), ", "))
+	}
+
+	return provider(log, opts), nil
+}
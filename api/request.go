@@ -0,0 +1,387 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// secretParams are query parameters known to carry credentials
+var secretParams = []string{"api_key", "apikey", "client_id", "access_token", "token"}
+
+// DefaultMaxBodySize is the default limit of the size of API response bodies
+const DefaultMaxBodySize = 4 << 20
+
+// Options are settings shared by all providers
+type Options struct {
+	// Headers are added to every request made by the provider
+	Headers http.Header
+	// BearerToken is sent as an Authorization header if set. If empty, the
+	// GNOME_SPOTLIGHT_<PROVIDER>_TOKEN environment variable is used instead.
+	BearerToken string
+	// Client is used to send requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	// Locale overrides the locale derived from the environment
+	Locale *Locale
+	// CountryURL is a geo-IP endpoint responding with the country code of the
+	// client as plain text. If set, the country is looked up there, overriding the
+	// country derived from the environment. If the lookup fails, the derived
+	// country is used
+	CountryURL string
+	// Params are added to the query of every API request, replacing parameters of
+	// the same name
+	Params url.Values
+	// MaxBodySize limits the size of API response bodies, after decompression.
+	// Reading beyond it fails. Defaults to DefaultMaxBodySize
+	MaxBodySize int64
+	// SourceFile is the file listing image URLs for the urllist provider, one per
+	// line
+	SourceFile string
+	// LastURL is the URL of the image applied last, which the urllist provider
+	// avoids picking again
+	LastURL string
+	// Rand is used by providers picking images randomly, so that a seeded
+	// generator makes the pick deterministic. Defaults to a random seed
+	Rand *rand.Rand
+	// AllowHTTP allows API requests over plain HTTP, which are logged as a warning.
+	// By default, only HTTPS URLs are requested
+	AllowHTTP bool
+	// CacheDir is the directory API responses carrying an ETag are cached in.
+	// Cached responses are revalidated with If-None-Match and reused if the API
+	// responds with 304 Not Modified. If empty, responses aren't cached
+	CacheDir string
+}
+
+// requester builds and sends requests on behalf of a provider
+type requester struct {
+	log  *slog.Logger
+	name string
+	opts Options
+	// fallback is the locale the provider declares to use if none can be derived
+	// from the environment. If nil, failing to derive the locale is an error
+	fallback *Locale
+}
+
+// tokenEnv returns the name of the environment variable holding the token for
+// the provider with the given name
+func tokenEnv(name string) string {
+	return "GNOME_SPOTLIGHT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_TOKEN"
+}
+
+// localeParams are the URL template placeholders filled from the locale
+var localeParams = map[string]func(Locale) string{
+	"{locale}":  func(l Locale) string { return l.Tag },
+	"{country}": func(l Locale) string { return l.Country },
+	"{market}":  Locale.Market,
+}
+
+// expandURL replaces the locale placeholders in template with the corresponding
+// URL escaped values. Providers declare which parameters they consume through the
+// placeholders in their template, so the locale is only resolved if template
+// contains any of them
+func (r *requester) expandURL(ctx context.Context, template string) (string, error) {
+	var locale *Locale
+	for param, value := range localeParams {
+		if !strings.Contains(template, param) {
+			continue
+		}
+
+		if locale == nil {
+			l, err := r.locale(ctx)
+			if err != nil {
+				return "", err
+			}
+			locale = &l
+		}
+
+		template = strings.ReplaceAll(template, param, url.QueryEscape(value(*locale)))
+	}
+
+	return template, nil
+}
+
+// locale returns the configured locale, or resolves it from the environment
+func (r *requester) locale(ctx context.Context) (Locale, error) {
+	if r.opts.Locale != nil {
+		return *r.opts.Locale, nil
+	}
+
+	locale, err := ResolveLocale(r.log)
+	if err != nil {
+		if r.fallback == nil {
+			return Locale{}, err
+		}
+
+		r.log.Warn("failed to derive locale, using default of provider", "locale", r.fallback.Tag, "country", r.fallback.Country, "error", err)
+		locale = *r.fallback
+	}
+
+	if r.opts.CountryURL != "" {
+		country, err := r.countryFromIP(ctx, r.opts.CountryURL)
+		if err != nil {
+			r.log.Warn("failed to look up country from ip, using locale", "country", locale.Country, "error", err)
+			return locale, nil
+		}
+
+		r.log.Debug("looked up country from ip", "value", country)
+
+		locale.Country = country
+	}
+
+	return locale, nil
+}
+
+// withParams returns rawURL with the configured query parameters added
+func (r *requester) withParams(rawURL string) (string, error) {
+	if len(r.opts.Params) == 0 {
+		return rawURL, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("parse url: %w", err)
+	}
+
+	query := u.Query()
+	for key, values := range r.opts.Params {
+		query[key] = values
+	}
+
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// requestURL returns the URL requested for template, with the locale placeholders
+// expanded and the configured query parameters added
+func (r *requester) requestURL(ctx context.Context, template string) (string, error) {
+	rawURL, err := r.expandURL(ctx, template)
+	if err != nil {
+		return "", err
+	}
+
+	return r.withParams(rawURL)
+}
+
+// CheckScheme returns an error if rawURL is not an HTTPS URL, unless allowHTTP is
+// set and it is a plain HTTP URL. It reports whether the URL is plain HTTP
+func CheckScheme(rawURL string, allowHTTP bool) (bool, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false, fmt.Errorf("parse url: %w", err)
+	}
+
+	switch scheme := strings.ToLower(u.Scheme); {
+	case scheme == "https":
+		return false, nil
+	case scheme == "http" && allowHTTP:
+		return true, nil
+	default:
+		return false, fmt.Errorf("url scheme %q is not allowed, expected https", u.Scheme)
+	}
+}
+
+// checkScheme checks rawURL with CheckScheme, warning about plain HTTP URLs
+func (r *requester) checkScheme(rawURL string) error {
+	insecure, err := CheckScheme(rawURL, r.opts.AllowHTTP)
+	if err != nil {
+		return err
+	}
+
+	if insecure {
+		r.log.Warn("calling api over plain http", "url", RedactURL(rawURL))
+	}
+
+	return nil
+}
+
+// get performs a GET request to url with the configured authentication headers
+func (r *requester) get(ctx context.Context, rawURL string) (*http.Response, error) {
+	rawURL, err := r.withParams(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.checkScheme(rawURL); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+
+	for key, values := range r.opts.Headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	token := r.opts.BearerToken
+	if token == "" {
+		token = os.Getenv(tokenEnv(r.name))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	cached := r.cached(rawURL)
+	if cached != nil {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	r.log.Debug("calling api", "url", RedactURL(rawURL), "headers", redactHeaders(req.Header))
+
+	client := r.opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		// the url is part of the error message, which ends up in the logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = RedactURL(urlErr.URL)
+		}
+		return nil, err
+	}
+
+	// the request may have been redirected to another url
+	if err := r.checkScheme(res.Request.URL.String()); err != nil {
+		res.Body.Close()
+		return nil, fmt.Errorf("after redirect: %w", err)
+	}
+
+	// the transport only decompresses transparently if it requested compression
+	// itself, which isn't the case if Accept-Encoding was set explicitly or the
+	// server compresses regardless
+	if !res.Uncompressed && strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			res.Body.Close()
+			return nil, fmt.Errorf("decompress response body: %w", err)
+		}
+
+		r.log.Debug("decompressing gzip encoded response")
+
+		res.Body = &gzipBody{Reader: zr, body: res.Body}
+		res.Header.Del("Content-Encoding")
+		res.Header.Del("Content-Length")
+		res.ContentLength = -1
+	}
+
+	limit := r.opts.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+	res.Body = &limitedBody{body: res.Body, remaining: limit, limit: limit}
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && cached != nil:
+		r.log.Debug("api response not modified, using cached response", "etag", cached.ETag)
+
+		res.Body.Close()
+		res.StatusCode, res.Status = http.StatusOK, "200 OK (cached)"
+		res.Body = io.NopCloser(bytes.NewReader(cached.Body))
+		res.ContentLength = int64(len(cached.Body))
+	case res.StatusCode == http.StatusOK:
+		if err := r.cache(rawURL, res); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// limitedBody is a response body failing to read beyond limit bytes
+type limitedBody struct {
+	body      io.ReadCloser
+	remaining int64
+	limit     int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// a body of exactly limit bytes is fine, only fail if there is more
+		var probe [1]byte
+		if n, _ := b.body.Read(probe[:]); n > 0 {
+			return 0, fmt.Errorf("response body exceeds %d bytes", b.limit)
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+
+	n, err := b.body.Read(p)
+	b.remaining -= int64(n)
+
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.body.Close()
+}
+
+// gzipBody is a response body decompressed with gzip
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+// RedactURL returns rawURL with the values of known secret query parameters and
+// any user info masked, so that it can be logged safely
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	if u.User != nil {
+		u.User = url.User("REDACTED")
+	}
+
+	query := u.Query()
+	redacted := false
+	for key := range query {
+		for _, secret := range secretParams {
+			if strings.EqualFold(key, secret) {
+				query.Set(key, "REDACTED")
+				redacted = true
+			}
+		}
+	}
+
+	if redacted {
+		u.RawQuery = query.Encode()
+	}
+
+	return u.String()
+}
+
+// redactHeaders returns a copy of header suitable for logging, with the values
+// of all headers masked since they may carry credentials
+func redactHeaders(header http.Header) http.Header {
+	redacted := make(http.Header, len(header))
+	for key := range header {
+		redacted[key] = []string{"REDACTED"}
+	}
+
+	return redacted
+}
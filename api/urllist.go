@@ -0,0 +1,114 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+type urllist struct {
+	log  *slog.Logger
+	opts Options
+}
+
+// NewURLList returns the provider picking images from a file listing their URLs,
+// see Options.SourceFile
+func NewURLList(log *slog.Logger, opts Options) API {
+	return &urllist{log: log, opts: opts}
+}
+
+func (api *urllist) Name() string {
+	return "urllist"
+}
+
+func (api *urllist) Get(ctx context.Context) (Image, error) {
+	if api.opts.SourceFile == "" {
+		return Image{}, errors.New("urllist source requires a source file")
+	}
+
+	urls, err := readURLList(api.opts.SourceFile)
+	if err != nil {
+		return Image{}, err
+	}
+
+	if len(urls) == 0 {
+		return Image{}, fmt.Errorf("source file %s contains no urls", api.opts.SourceFile)
+	}
+
+	// the image used last is only picked again if there is no other
+	candidates := urls
+	if len(urls) > 1 {
+		candidates = nil
+		for _, u := range urls {
+			if u != api.opts.LastURL {
+				candidates = append(candidates, u)
+			}
+		}
+	}
+
+	var i int
+	if api.opts.Rand != nil {
+		i = api.opts.Rand.IntN(len(candidates))
+	} else {
+		i = rand.IntN(len(candidates))
+	}
+
+	url := candidates[i]
+
+	api.log.Debug("picked url from source file", "value", RedactURL(url), "candidates", len(candidates))
+
+	return Image{URL: url}, nil
+}
+
+// urlListHosts returns the hosts of the URLs listed in the source file. The list
+// is provided by the user, so its hosts are trusted. If the file can't be read,
+// no hosts are returned and Get reports the error
+func urlListHosts(opts Options) []string {
+	urls, err := readURLList(opts.SourceFile)
+	if err != nil {
+		return nil
+	}
+
+	var hosts []string
+	for _, rawURL := range urls {
+		if u, err := url.Parse(rawURL); err == nil && u.Hostname() != "" && !slices.Contains(hosts, u.Hostname()) {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+
+	return hosts
+}
+
+// readURLList reads the URLs in the file at path, one per line. Blank lines and
+// lines starting with # are skipped
+func readURLList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open source file: %w", err)
+	}
+	defer file.Close()
+
+	var urls []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		urls = append(urls, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read source file: %w", err)
+	}
+
+	return urls, nil
+}
@@ -1,3 +1,5 @@
 module github.com/eric-carlsson/gnome-spotlight
 
 go 1.23.4
+
+require golang.org/x/image v0.30.0
@@ -1,53 +1,557 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path"
-	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+	"unicode"
 
 	"github.com/eric-carlsson/gnome-spotlight/api"
+	"github.com/eric-carlsson/gnome-spotlight/spotlight"
 )
 
 type Config struct {
-	debug    bool
-	dir      string
-	preserve uint
+	debug        bool
+	user         string
+	allUsers     bool
+	notifyErrors bool
+	resolveOnly  bool
+	preserveZero bool
+	allowedHosts string
+	source       string
+	fallbacks    []string
+	countryIP    bool
+	countryURL   string
+	requireHTTPS bool
+	forceLocale  bool
+	opts         spotlight.Options
 }
 
-type Application struct {
-	log      *slog.Logger
-	dir      string
-	preserve uint
-}
-
-// imagePrefix is the prefix prepended to image names. This is used to track what
-// and clean up old images downloaded by the app
-const imagePrefix = "gnome-spotlight_"
-
 func main() {
 	var config Config
 	flag.BoolVar(&config.debug, "debug", false, "Enable debug logging")
 	flag.StringVar(
-		&config.dir,
+		&config.opts.Dir,
 		"dir",
 		path.Join(os.Getenv("HOME"), ".local/share/backgrounds"),
 		"Directory for saving images",
 	)
-	flag.UintVar(
-		&config.preserve,
+	config.opts.Preserve = 3
+	flag.Func(
 		"preserve",
+		("Number of previous images to preserve, or all. If the number of saved " +
+			"images would exceed this amount, the oldest image is deleted. (default 3)"),
+		func(s string) error {
+			if s == "all" {
+				config.opts.Preserve = spotlight.PreserveAll
+				return nil
+			}
+
+			n, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				return errors.New("expected a number or all")
+			}
+
+			config.preserveZero = n == 0
+			config.opts.Preserve = uint(n)
+			return nil
+		},
+	)
+	flag.StringVar(
+		&config.opts.Prefix,
+		"prefix",
+		spotlight.DefaultPrefix,
+		"Prefix of managed image names. Instances using different prefixes clean up independently.",
+	)
+	flag.StringVar(
+		&config.opts.IncludePattern,
+		"include-pattern",
+		"",
+		"Glob pattern of file names considered for cleanup (default prefix followed by *)",
+	)
+	flag.StringVar(
+		&config.opts.ExcludePattern,
+		"exclude-pattern",
+		"",
+		"Glob pattern of file names never deleted by cleanup",
+	)
+	config.opts.Headers = http.Header{}
+	flag.Func(
+		"auth-header",
+		("Header sent with the requests of the provider selected by --source, in the " +
+			"form 'Name: value'. Not sent to other sources or redirect targets on other " +
+			"hosts. Can be repeated."),
+		func(s string) error {
+			key, value, ok := strings.Cut(s, ":")
+			if !ok || strings.TrimSpace(key) == "" {
+				return errors.New("expected 'Name: value'")
+			}
+			config.opts.Headers.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+			return nil
+		},
+	)
+	flag.StringVar(
+		&config.opts.BearerToken,
+		"bearer-token",
+		"",
+		("Bearer token sent with the requests of the provider selected by --source. " +
+			"Defaults to the GNOME_SPOTLIGHT_<SOURCE>_TOKEN environment variable of each source."),
+	)
+	flag.DurationVar(
+		&config.opts.MinInterval,
+		"min-interval",
+		0,
+		("Minimum time between successful runs, e.g. 24h. If the previous run " +
+			"was more recent, exit without fetching a new image. Setting this to 0 " +
+			"always fetches."),
+	)
+	flag.StringVar(
+		&config.opts.Proxy,
+		"proxy",
+		"",
+		"Proxy URL for API requests and image downloads. Defaults to the proxy environment variables.",
+	)
+	flag.BoolVar(&config.opts.ProxyAPIOnly, "proxy-api-only", false, "Only use the proxy for API requests")
+	flag.BoolVar(&config.opts.ProxyImageOnly, "proxy-image-only", false, "Only use the proxy for image downloads")
+	flag.UintVar(
+		&config.opts.BackendRetries,
+		"backend-retries",
+		0,
+		("Number of times a failed dconf write is retried, at most 1. Provider " +
+			"requests and image downloads are not retried."),
+	)
+	flag.DurationVar(
+		&config.opts.DBusTimeout,
+		"dbus-timeout",
+		15*time.Second,
+		("Timeout for each call to dconf, gsettings, gdbus, xrandr and notify-send, after which " +
+			"the call fails instead of blocking the run"),
+	)
+	flag.DurationVar(
+		&config.opts.ConnectTimeout,
+		"timeout-connect",
+		10*time.Second,
+		"Timeout for establishing connections, separate from the time taken by the request itself",
+	)
+	flag.DurationVar(
+		&config.opts.KeepAlive,
+		"keep-alive",
+		30*time.Second,
+		"Keep-alive period of connections. Negative values disable keep-alives.",
+	)
+	flag.StringVar(
+		&config.opts.ClientCert,
+		"client-cert",
+		"",
+		"PEM encoded client certificate presented to servers requesting one, e.g. inspecting proxies. Requires --client-key.",
+	)
+	flag.StringVar(&config.opts.ClientKey, "client-key", "", "PEM encoded key of the client certificate")
+	flag.StringVar(
+		&config.opts.CACert,
+		"ca-cert",
+		"",
+		"PEM encoded CA certificate to trust in addition to the system certificates",
+	)
+	flag.Float64Var(
+		&config.opts.SlowWarn,
+		"slow-warn",
+		0,
+		"Log a warning if the image downloads slower than this many MB/s. Setting this to 0 disables the warning.",
+	)
+	flag.BoolVar(
+		&config.opts.ShortWarn,
+		"short-warn",
+		false,
+		("Keep an image of which fewer bytes are received than the server advertised " +
+			"with Content-Length instead of failing, logging a warning as it may be incomplete"),
+	)
+	flag.StringVar(
+		&config.opts.Which,
+		"which",
+		"both",
+		("Which background variant to update, one of light, dark or both. The " +
+			"screensaver image is updated together with the light variant."),
+	)
+	flag.BoolVar(
+		&config.opts.PerSourceDir,
+		"per-source-dir",
+		false,
+		("Save images in a subdirectory of --dir named after their source. --preserve " +
+			"applies to each subdirectory separately."),
+	)
+	flag.BoolVar(
+		&config.opts.Probe,
+		"probe",
+		false,
+		("Check that the image is available with a HEAD request before downloading " +
+			"it, trying the fallback sources if it isn't"),
+	)
+	flag.BoolVar(
+		&config.opts.DedupeSeen,
+		"dedupe-across-sessions",
+		false,
+		("Remember the URLs of all downloaded images in the state directory and skip " +
+			"images downloaded before and since cleaned up, trying the fallback sources instead"),
+	)
+	flag.BoolVar(
+		&config.opts.PerceptualDedupe,
+		"perceptual-dedupe",
+		false,
+		("Compare images by perceptual hash, rejecting downloaded images visually " +
+			"identical to a managed image, e.g. the same image from another source. " +
+			"The dedupe command deletes visually identical images as well."),
+	)
+	flag.UintVar(
+		&config.opts.PHashDistance,
+		"perceptual-distance",
+		4,
+		"With --perceptual-dedupe, the number of bits out of 64 perceptual hashes of visually identical images may differ in",
+	)
+	flag.DurationVar(
+		&config.opts.ForgetAfter,
+		"forget-after",
+		0,
+		("With --dedupe-across-sessions, forget downloaded images after this long, so " +
+			"that they can be downloaded again, e.g. 2160h. Setting this to 0 never forgets."),
+	)
+	flag.BoolVar(
+		&config.opts.OverwriteUnmanaged,
+		"overwrite-existing-unmanaged",
+		false,
+		("Allow replacing files that are not managed images, such as files excluded " +
+			"from cleanup or existing files in --copy-to"),
+	)
+	flag.BoolVar(
+		&config.opts.AtomicKeys,
+		"atomic-keys",
+		false,
+		("If writing one of the background keys fails, restore the keys already " +
+			"written to their previous values, on a best-effort basis"),
+	)
+	flag.BoolVar(
+		&config.opts.Force,
+		"force",
+		false,
+		("Replace an existing image with the same name instead of failing. Without it, " +
+			"an existing image is only downloaded again if it changed on the server."),
+	)
+	flag.BoolVar(
+		&config.opts.HashName,
+		"image-hash-name",
+		false,
+		("Name images by the SHA-256 hash of their content instead of their URL, so " +
+			"that an image already downloaded from another URL is detected"),
+	)
+	flag.StringVar(
+		&config.opts.ArchiveDir,
+		"archive-dir",
+		"",
+		("Directory to move images exceeding the preserve threshold to, instead of deleting " +
+			"them. Their thumbnails are moved along and their provenance is kept in index.json there."),
+	)
+	flag.BoolVar(&config.opts.NoCleanup, "no-cleanup", false, "Keep all images regardless of --preserve")
+	flag.BoolVar(
+		&config.opts.CleanupDryRun,
+		"cleanup-dry-run",
+		false,
+		("Only log the images cleanup would delete or archive, without touching them. " +
+			"Images are still downloaded and set as usual."),
+	)
+	flag.BoolVar(
+		&config.opts.QuietOnUnchanged,
+		"quiet-on-unchanged",
+		false,
+		("Don't log anything if the run neither downloads nor applies an image, e.g. " +
+			"because the image was already downloaded or --min-interval has not elapsed"),
+	)
+	flag.BoolVar(
+		&config.opts.PreClean,
+		"pre-clean",
+		false,
+		("Also clean up before downloading, making room for the new image so the " +
+			"number of images never exceeds --preserve. The current background is kept."),
+	)
+	flag.BoolVar(
+		&config.resolveOnly,
+		"resolve-only",
+		false,
+		"Print the image URL resolved by the provider and exit without downloading or setting it",
+	)
+	flag.StringVar(
+		&config.allowedHosts,
+		"allowed-hosts",
+		"",
+		("Comma separated list of hosts images may be downloaded from. Subdomains " +
+			"of listed hosts are allowed. Setting this to an empty string allows all hosts. " +
+			"(default the hosts the sources serve images from, for urllist the hosts of the listed URLs)"),
+	)
+	flag.BoolVar(
+		&config.requireHTTPS,
+		"require-https",
+		true,
+		("Reject API and image URLs that are not https, also after redirects. If " +
+			"disabled, plain http is allowed with a warning."),
+	)
+	flag.UintVar(
+		&config.opts.Thumbnail,
+		"thumbnail",
+		0,
+		("Write a JPEG thumbnail next to each image, scaled to at most this many " +
+			"pixels in either dimension. Setting this to 0 disables thumbnails."),
+	)
+	flag.StringVar(
+		&config.user,
+		"user",
+		"",
+		("Name or uid of the user whose background is managed. Requires running " +
+			"as root, privileges are dropped to the user. --dir defaults to the " +
+			"backgrounds directory of the user."),
+	)
+	flag.BoolVar(
+		&config.opts.NoNetwork,
+		"no-network",
+		false,
+		("Never access the network. Instead of downloading a new image, the image " +
+			"last applied is applied again, failing if there is none."),
+	)
+	flag.BoolVar(
+		&config.opts.RandomizeOnStart,
+		"randomize-on-start",
+		false,
+		("Apply a random managed image other than the current background instead of " +
+			"downloading a new one, except every --download-every runs or if there are " +
+			"fewer than --min-pool managed images"),
+	)
+	flag.UintVar(
+		&config.opts.DownloadEvery,
+		"download-every",
+		5,
+		"With --randomize-on-start, download a new image every this many runs",
+	)
+	flag.UintVar(
+		&config.opts.MinPool,
+		"min-pool",
 		3,
-		("Number of previous images to preserve. If the number of saved images " +
-			"would exceed this amount, the oldest image is deleted. Setting this " +
-			"to 0 preserves all images."),
+		"With --randomize-on-start, download a new image while there are fewer managed images than this",
+	)
+	flag.BoolVar(
+		&config.notifyErrors,
+		"notify-on-error",
+		false,
+		"Show a desktop notification with notify-send if the run fails",
+	)
+	flag.BoolVar(
+		&config.allUsers,
+		"all-users",
+		false,
+		("Manage the background of every regular user with a session, as if run " +
+			"with --user for each. Requires running as root."),
+	)
+	flag.BoolVar(
+		&config.opts.RefreshIfStale,
+		"refresh-if-stale",
+		false,
+		("If the background no longer points at a managed image, reapply the image " +
+			"last applied instead of downloading a new one"),
+	)
+	flag.StringVar(
+		&config.opts.CopyTo,
+		"copy-to",
+		"",
+		("Directory to also copy new images to. Copies are not cleaned up. Earlier " +
+			"copies are replaced, other files only with --overwrite-existing-unmanaged."),
+	)
+	flag.StringVar(
+		&config.source,
+		"source",
+		"microsoft",
+		fmt.Sprintf("Source to fetch images from, one of %s", strings.Join(api.Sources(), ", ")),
+	)
+	flag.StringVar(
+		&config.opts.SourceFile,
+		"source-file",
+		"",
+		("File listing image URLs for the urllist source, one per line. Blank lines " +
+			"and lines starting with # are ignored. Unless --allowed-hosts is set, the " +
+			"hosts of the listed URLs are allowed."),
+	)
+	flag.Func(
+		"fallback-source",
+		("Source to try if the previous sources fail, in order. Can be repeated " +
+			"or given as a comma separated list."),
+		func(s string) error {
+			for _, name := range strings.Split(s, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					config.fallbacks = append(config.fallbacks, name)
+				}
+			}
+			return nil
+		},
+	)
+	flag.Func(
+		"source-weights",
+		("Pick the source randomly each run according to weights, e.g. " +
+			"microsoft=3,lockscreen=1. The other weighted sources serve as fallbacks. " +
+			"Replaces --source and --fallback-source."),
+		func(s string) error {
+			config.opts.SourceWeights = map[string]uint{}
+			for _, entry := range strings.Split(s, ",") {
+				name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+				weight, err := strconv.ParseUint(value, 10, 32)
+				if !ok || name == "" || err != nil {
+					return errors.New("expected name=weight")
+				}
+				config.opts.SourceWeights[name] = uint(weight)
+			}
+			return nil
+		},
+	)
+	flag.Uint64Var(
+		&config.opts.Seed,
+		"seed",
+		0,
+		"Seed for the random selection of sources, of the image applied with --randomize-on-start and of the URL picked by the urllist source, making them deterministic. Setting this to 0 seeds randomly.",
+	)
+	flag.Func(
+		"exclude-keywords",
+		("Comma separated keywords rejecting images whose title or copyright contains " +
+			"any of them, ignoring case. The fallback sources are tried instead. Can be repeated."),
+		func(s string) error {
+			config.opts.ExcludeKeywords = append(config.opts.ExcludeKeywords, strings.Split(s, ",")...)
+			return nil
+		},
+	)
+	flag.Func(
+		"include-keywords",
+		("Comma separated keywords of which the title, copyright or description of " +
+			"images must contain at least one, ignoring case. Otherwise the fallback " +
+			"sources are tried instead. Exclude keywords take precedence. Can be repeated."),
+		func(s string) error {
+			config.opts.IncludeKeywords = append(config.opts.IncludeKeywords, strings.Split(s, ",")...)
+			return nil
+		},
+	)
+	config.opts.FileMode, config.opts.DirMode = 0o644, 0o755
+	flag.Func(
+		"image-permissions",
+		"Permissions of created image files, in octal (default 644)",
+		parseMode(&config.opts.FileMode),
+	)
+	flag.Func(
+		"image-dir-permissions",
+		"Permissions of created image directories, in octal (default 755)",
+		parseMode(&config.opts.DirMode),
+	)
+	flag.BoolVar(
+		&config.opts.VerifyDecode,
+		"verify-decode",
+		false,
+		"Decode downloaded images and reject them if they are corrupt, before setting them",
+	)
+	flag.Float64Var(
+		&config.opts.MinContrast,
+		"min-contrast",
+		0,
+		("Reject images whose contrast, measured as the standard deviation of the " +
+			"luminance on a scale of 0 to 255, is below this value. Setting this to 0 " +
+			"disables the check."),
+	)
+	flag.StringVar(
+		&config.opts.Transform,
+		"transform",
+		"",
+		("Comma separated transforms applied in order to downloaded images before " +
+			"setting them, e.g. resize=1920x1080,blur=8,format=png. Supported are " +
+			"resize=WxH, fit=WxH, blur=radius and format=jpeg|png. Without a format, images in " +
+			"formats that can't be written, such as WebP, are converted to JPEG."),
+	)
+	flag.BoolVar(
+		&config.opts.FitScreen,
+		"fit-screen",
+		false,
+		("Crop images around their center to the aspect ratio of the screen and " +
+			"scale them down to its resolution, so they fill it exactly"),
+	)
+	flag.StringVar(
+		&config.opts.Screen,
+		"screen",
+		"",
+		"Resolution of the screen as WxH, e.g. 1920x1080 (default resolution of the primary monitor)",
+	)
+	flag.BoolVar(
+		&config.opts.LetterboxColors,
+		"letterbox-colors",
+		false,
+		("Set the background primary and secondary colors from the edge and average " +
+			"color of the image, so that bars around images not filling the screen match it"),
+	)
+	flag.BoolVar(
+		&config.opts.ColorScheme,
+		"set-interface-color-scheme",
+		false,
+		("Switch the interface to the dark color scheme for dark images and to the " +
+			"default one for bright images, based on their average brightness"),
+	)
+	config.opts.APIParams = url.Values{}
+	flag.Func(
+		"api-param",
+		("Query parameter added to provider requests, in the form key=value. " +
+			"Replaces the default value of a parameter with the same key. Can be repeated."),
+		func(s string) error {
+			key, value, ok := strings.Cut(s, "=")
+			if !ok || key == "" || strings.ContainsFunc(key, unicode.IsSpace) {
+				return errors.New("expected key=value")
+			}
+			config.opts.APIParams.Add(key, value)
+			return nil
+		},
+	)
+	flag.BoolVar(
+		&config.countryIP,
+		"country-from-ip",
+		false,
+		("Look up the country of provider requests from the public IP address, " +
+			"overriding the country derived from the locale. If the lookup fails, the " +
+			"locale is used."),
+	)
+	flag.StringVar(
+		&config.countryURL,
+		"country-url",
+		api.DefaultCountryURL,
+		"Geo-IP endpoint used by --country-from-ip, responding with the country code as plain text",
+	)
+	flag.BoolVar(
+		&config.forceLocale,
+		"force-locale-default",
+		false,
+		("Request images for the en-US locale and the US country, without deriving " +
+			"them from the environment. Useful if the locale can't be derived reliably."),
+	)
+	flag.Int64Var(
+		&config.opts.MaxAPIBodySize,
+		"max-api-body-size",
+		api.DefaultMaxBodySize,
+		"Maximum size of provider API responses in bytes, guarding against enormous responses",
+	)
+	flag.StringVar(
+		&config.opts.StateDir,
+		"state-dir",
+		"",
+		("Directory for state such as the image index and time of the last run " +
+			"(default a directory below $XDG_STATE_HOME/gnome-spotlight named after a hash " +
+			"of --dir and --prefix). Instances managing different images should not share " +
+			"a state directory."),
 	)
 	flag.Parse()
 
@@ -58,164 +562,229 @@ func main() {
 
 	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
 
-	app := &Application{
-		log:      log,
-		dir:      config.dir,
-		preserve: config.preserve,
-	}
+	if config.allUsers {
+		if config.user != "" {
+			log.Error("invalid configuration, --all-users and --user are mutually exclusive")
+			os.Exit(2)
+		}
 
-	if err := app.Run(); err != nil {
-		log.Error("runtime error", "error", err)
-		os.Exit(1)
-	}
-}
+		if os.Geteuid() != 0 {
+			log.Error("invalid configuration, --all-users requires running as root")
+			os.Exit(2)
+		}
 
-// Run is the main entrypoint of the application
-func (a *Application) Run() error {
-	path, err := a.newImage()
-	if err != nil {
-		return fmt.Errorf("new image: %w", err)
-	}
+		users, err := sessionUsers()
+		if err != nil {
+			log.Error("failed to get users with a session", "error", err)
+			os.Exit(1)
+		}
 
-	if err := a.writeToDconf(path); err != nil {
-		return fmt.Errorf("write to dconf: %w", err)
-	}
+		log.Info("managing background of users with a session", "users", users)
 
-	if err := a.cleanImages(a.preserve); err != nil {
-		return fmt.Errorf("clean images: %w", err)
-	}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
 
-	return nil
-}
+		if err := runForUsers(ctx, log, users, withoutFlag(os.Args[1:], "all-users")); err != nil {
+			log.Error("runtime error", "error", err)
+			os.Exit(1)
+		}
 
-// cleanImages deletes old images if current number is higher than preserve threshold
-func (a *Application) cleanImages(preserve uint) error {
-	// 0 means keep all
-	if preserve == 0 {
-		return nil
+		return
 	}
 
-	entries, err := os.ReadDir(a.dir)
-	if err != nil {
-		return fmt.Errorf("read dir: %w", err)
+	if config.preserveZero {
+		log.Warn("--preserve 0 is deprecated, use --preserve all to preserve all images")
 	}
 
-	var files []os.FileInfo
-	for _, entry := range entries {
-		if strings.HasPrefix(entry.Name(), imagePrefix) {
-			a.log.Debug("found managed image", "value", entry.Name())
-
-			info, err := entry.Info()
-			if err != nil {
-				return fmt.Errorf("get file info: %w", err)
-			}
+	if config.user != "" {
+		home, err := switchUser(config.user)
+		if err != nil {
+			log.Error("failed to switch user", "value", config.user, "error", err)
+			os.Exit(1)
+		}
 
-			files = append(files, info)
+		if !isFlagSet("dir") {
+			config.opts.Dir = path.Join(home, ".local/share/backgrounds")
 		}
-	}
 
-	if len(files) <= int(preserve) {
-		return nil
+		log.Info("switched user", "value", config.user, "dir", config.opts.Dir)
 	}
 
-	a.log.Info("found more images than target amount, deleting oldest", "current", len(files), "target", preserve)
+	if config.opts.Prefix == "" {
+		log.Error("invalid prefix, must not be empty")
+		os.Exit(2)
+	}
 
-	slices.SortFunc(files, func(a, b os.FileInfo) int {
-		return a.ModTime().Compare(b.ModTime())
+	config.opts.Sources = append([]string{config.source}, config.fallbacks...)
+	config.opts.AuthSource = config.source
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "allowed-hosts" {
+			config.opts.AllowedHosts = strings.Split(config.allowedHosts, ",")
+		}
 	})
+	config.opts.AllowHTTP = !config.requireHTTPS
+	if config.countryIP {
+		if config.forceLocale {
+			log.Error("invalid configuration, --country-from-ip and --force-locale-default are mutually exclusive")
+			os.Exit(2)
+		}
 
-	for _, file := range files[:len(files)-int(preserve)] {
-		a.log.Info("deleting image", "value", file.Name())
+		config.opts.CountryURL = config.countryURL
+	}
+	if config.forceLocale {
+		locale := api.DefaultLocale
+		config.opts.Locale = &locale
+	}
 
-		if err := os.Remove(path.Join(a.dir, file.Name())); err != nil {
-			return fmt.Errorf("delete image: %w", err)
+	app, err := spotlight.New(log, config.opts)
+	if err != nil {
+		log.Error("invalid configuration", "error", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch cmd := flag.Arg(0); {
+	case cmd == "" && config.resolveOnly:
+		var image api.Image
+		if image, err = app.Resolve(ctx); err == nil {
+			fmt.Println(image.URL)
 		}
+	case cmd == "":
+		err = app.Run(ctx)
+	case cmd == "list":
+		err = app.List(os.Stdout)
+	case cmd == "dump":
+		err = app.Dump(ctx, os.Stdout)
+	case cmd == "dedupe":
+		err = app.Dedupe(ctx, os.Stdout)
+	case cmd == "stats":
+		err = app.Stats(os.Stdout)
+	case cmd == "reapply":
+		err = app.Reapply(ctx)
+	case cmd == "export":
+		err = export(app, flag.Arg(1))
+	case cmd == "import":
+		err = importArchive(app, flag.Arg(1))
+	case cmd == "probe-locale":
+		err = app.ProbeLocale(ctx, os.Stdout)
+	case cmd == "set":
+		err = set(ctx, app, flag.Arg(1))
+	default:
+		log.Error("unknown command", "value", cmd)
+		os.Exit(2)
 	}
 
-	return nil
-}
+	if err != nil {
+		log.Error("runtime error", "error", err)
 
-// writeToDconf sets dconf entries for background image to imagePath
-func (a *Application) writeToDconf(imagePath string) error {
-	keys := []string{
-		"/org/gnome/desktop/background/picture-uri",
-		"/org/gnome/desktop/background/picture-uri-dark",
-		"/org/gnome/desktop/screensaver/picture-uri",
-	}
-
-	for _, key := range keys {
-		// note quotes, this is necessary for dconf to recognize value as string
-		value := fmt.Sprintf("'file://%s'", imagePath)
-
-		a.log.Info("writing dconf entry", "key", key, "value", value)
-
-		if _, err := exec.Command(
-			"dconf",
-			"write",
-			key,
-			value,
-		).Output(); err != nil {
-			var exitErr *exec.ExitError
-			if errors.As(err, &exitErr) {
-				return fmt.Errorf("execute dconf write: %w: %s", err, exitErr.Stderr)
+		if config.notifyErrors {
+			if err := notifyError(err, config.opts.DBusTimeout); err != nil {
+				log.Warn("failed to send error notification", "error", err)
 			}
-			return fmt.Errorf("execute dconf write: %w", err)
 		}
-	}
 
-	return nil
+		os.Exit(1)
+	}
 }
 
-// newImages downloads a new image
-func (a *Application) newImage() (string, error) {
-	api := api.NewMicrosoft(a.log)
-	url, err := api.Get()
-	if err != nil {
-		return "", fmt.Errorf("error getting image url: %w", err)
+// set applies the image file at path, or read from stdin if path is -
+func set(ctx context.Context, app *spotlight.Spotlight, path string) error {
+	if path == "" {
+		return errors.New("set requires an image file, or - to read it from stdin")
 	}
 
-	a.log.Info("fetched new image from api")
-
-	a.log.Debug("extraced image url from response", "value", url)
+	r := os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("open image: %w", err)
+		}
+		defer file.Close()
 
-	res, err := http.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("failed to fetch image: %w", err)
+		r = file
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("received non-ok response code when fetching image: %d", res.StatusCode)
-	}
+	_, err := app.Set(ctx, r)
+	return err
+}
 
-	a.log.Info("downloaded image")
+// export writes an archive of the managed images to the file at path
+func export(app *spotlight.Spotlight, path string) error {
+	if path == "" {
+		return errors.New("export requires the archive file to write")
+	}
 
-	info, err := os.Stat(a.dir)
+	file, err := os.Create(path)
 	if err != nil {
-		return "", fmt.Errorf("stat image directory: %w", err)
+		return fmt.Errorf("create archive: %w", err)
 	}
+	defer file.Close()
 
-	if !info.IsDir() {
-		return "", fmt.Errorf("dir exists but is not a directory")
+	if err := app.Export(file); err != nil {
+		os.Remove(path)
+		return err
 	}
 
-	path := path.Join(a.dir, imagePrefix+path.Base(url))
+	return file.Close()
+}
 
-	if _, err = os.Stat(path); !errors.Is(err, os.ErrNotExist) {
-		return "", fmt.Errorf("image already exists")
+// importArchive restores the managed images from the archive file at path
+func importArchive(app *spotlight.Spotlight, path string) error {
+	if path == "" {
+		return errors.New("import requires the archive file to read")
 	}
 
-	file, err := os.Create(path)
+	file, err := os.Open(path)
 	if err != nil {
-		return "", fmt.Errorf("create image file: %w", err)
+		return fmt.Errorf("open archive: %w", err)
 	}
+	defer file.Close()
 
-	n, err := io.Copy(file, res.Body)
-	if err != nil {
-		return "", fmt.Errorf("write image file: %w", err)
+	return app.Import(file, os.Stdout)
+}
+
+// parseMode returns a flag parsing function storing an octal permission in mode
+func parseMode(mode *os.FileMode) func(string) error {
+	return func(s string) error {
+		m, err := strconv.ParseUint(s, 8, 32)
+		if err != nil || m > 0o777 {
+			return errors.New("expected octal permissions, e.g. 644")
+		}
+
+		*mode = os.FileMode(m)
+		return nil
+	}
+}
+
+// withoutFlag returns args with the boolean flag of the given name removed
+func withoutFlag(args []string, name string) []string {
+	var out []string
+	for i, arg := range args {
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+
+		flag, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && flag == name {
+			continue
+		}
+
+		out = append(out, arg)
 	}
 
-	a.log.Info("wrote image to file", "bytes", n, "path", path)
+	return out
+}
+
+// isFlagSet reports whether the flag with the given name was set on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
 
-	return path, nil
+	return set
 }
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// notifyError shows a desktop notification about err with notify-send, which is
+// killed if it doesn't complete within timeout. Like the D-Bus timeout of
+// spotlight.Options, a timeout of 0 means 15s
+func notifyError(err error, timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = 15 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(
+		ctx,
+		"notify-send",
+		"--urgency=critical",
+		"--app-name=gnome-spotlight",
+		"Failed to update background",
+		err.Error(),
+	)
+	cmd.WaitDelay = time.Second
+
+	return cmd.Run()
+}
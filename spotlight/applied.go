@@ -0,0 +1,43 @@
+package spotlight
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// appliedFile is the name of the state file recording the path of the image last
+// applied as background
+const appliedFile = "last-applied"
+
+// recordApplied writes path as the image last applied
+func (s *Spotlight) recordApplied(path string) error {
+	if err := s.writeState(appliedFile, []byte(path+"\n")); err != nil {
+		return fmt.Errorf("write last applied file: %w", err)
+	}
+
+	return nil
+}
+
+// appliedImage returns the path of the image last applied, if it still exists.
+// Without a record of one, e.g. before the first image was applied, the most
+// recent managed image is returned instead. The path is empty if there is neither
+func (s *Spotlight) appliedImage() (string, error) {
+	data, err := s.readState(appliedFile)
+	if err != nil {
+		return "", fmt.Errorf("read last applied file: %w", err)
+	}
+
+	if applied := strings.TrimSpace(string(data)); applied != "" {
+		if _, err := os.Stat(applied); err == nil {
+			return applied, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("stat last applied image: %w", err)
+		}
+
+		s.log.Debug("image last applied no longer exists, using most recent managed image", "value", applied)
+	}
+
+	return s.latestImage()
+}
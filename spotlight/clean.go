@@ -0,0 +1,326 @@
+package spotlight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// managedImage is a managed image along with its path
+type managedImage struct {
+	os.FileInfo
+	path string
+}
+
+// cleanImagesIn deletes old images in each image directory if their number is
+// higher than the preserve threshold, returning how many were deleted or
+// archived. The image named current is never deleted, see cleanImages
+func (s *Spotlight) cleanImagesIn(preserve uint, current string) (int, error) {
+	if preserve == PreserveAll {
+		return 0, nil
+	}
+
+	var removed int
+
+	for _, dir := range s.imageDirs() {
+		entries, err := s.managedEntries(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return removed, fmt.Errorf("list managed images: %w", err)
+		}
+
+		// counting needs no file info, only gather it if there is something to
+		// delete, which saves a stat per image in large directories
+		if len(entries) <= int(preserve) {
+			s.log.Debug("managed images within preserve threshold", "dir", dir, "current", len(entries), "target", preserve)
+			continue
+		}
+
+		files, err := s.managedImagesOf(dir, entries)
+		if err != nil {
+			return removed, fmt.Errorf("list managed images: %w", err)
+		}
+
+		n, err := s.cleanImages(files, preserve, current)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// cleanImages deletes the oldest of files if their number is higher than preserve
+// threshold, returning how many were deleted or archived. The image named current is never deleted, as its modification time
+// may be older than that of other images
+func (s *Spotlight) cleanImages(files []managedImage, preserve uint, current string) (int, error) {
+	// the current image always counts towards the preserved images
+	if i := slices.IndexFunc(files, func(f managedImage) bool { return f.Name() == current }); i != -1 {
+		file := files[i]
+		files = append(slices.Delete(files, i, i+1), file)
+	}
+
+	if len(files) <= int(preserve) {
+		return 0, nil
+	}
+
+	s.log.Info("found more images than target amount, deleting oldest", "current", len(files), "target", preserve)
+
+	if s.cleanDryRun {
+		for _, file := range files[:len(files)-int(preserve)] {
+			if s.archiveDir != "" {
+				s.log.Info("would archive image, cleanup dry run", "value", file.Name(), "dir", s.archiveDir)
+			} else {
+				s.log.Info("would delete image, cleanup dry run", "value", file.Name())
+			}
+		}
+
+		return 0, nil
+	}
+
+	if s.archiveDir != "" {
+		if err := mkdirAll(s.archiveDir, s.dirMode); err != nil {
+			return 0, fmt.Errorf("create archive dir: %w", err)
+		}
+	}
+
+	index, err := s.loadIndex()
+	if err != nil {
+		return 0, fmt.Errorf("load index: %w", err)
+	}
+
+	var removed int
+	archived := make(map[string]indexEntry)
+	for _, file := range files[:len(files)-int(preserve)] {
+		name := file.path
+
+		if s.archiveDir != "" {
+			s.log.Info("archiving image", "value", file.Name(), "dir", s.archiveDir)
+
+			dst := path.Join(s.archiveDir, file.Name())
+			if err := moveFile(name, dst); err != nil {
+				return removed, fmt.Errorf("archive image: %w", err)
+			}
+
+			if err := moveThumbnail(name, dst); err != nil {
+				return removed, fmt.Errorf("archive thumbnail: %w", err)
+			}
+
+			if entry, ok := index[file.Name()]; ok {
+				archived[file.Name()] = entry
+			}
+		} else {
+			s.log.Info("deleting image", "value", file.Name())
+
+			if err := os.Remove(name); err != nil {
+				return removed, fmt.Errorf("delete image: %w", err)
+			}
+
+			if err := removeThumbnail(name); err != nil {
+				return removed, fmt.Errorf("delete thumbnail: %w", err)
+			}
+		}
+
+		delete(index, file.Name())
+		removed++
+	}
+
+	if len(archived) > 0 {
+		if err := s.archiveIndex(archived); err != nil {
+			return removed, fmt.Errorf("save archive index: %w", err)
+		}
+	}
+
+	if err := s.saveIndex(index); err != nil {
+		return removed, fmt.Errorf("save index: %w", err)
+	}
+
+	return removed, nil
+}
+
+// preCleanImages deletes old images before a new image is fetched, leaving room
+// for it within the preserve threshold. The image currently set as background is
+// kept, so the background stays valid if the fetch fails. At least one image is
+// always kept, the final cleanup after fetching trims to the threshold. It
+// returns how many images were deleted or archived
+func (s *Spotlight) preCleanImages(ctx context.Context) (int, error) {
+	if s.preserve == PreserveAll {
+		return 0, nil
+	}
+
+	var current string
+	if background, err := s.currentImage(ctx); err != nil {
+		s.log.Warn("failed to get current background, it may be deleted by pre-clean", "error", err)
+	} else if s.inImageDir(background) {
+		current = filepath.Base(background)
+	}
+
+	return s.cleanImagesIn(max(s.preserve-1, 1), current)
+}
+
+// staleTmpAge is the age after which temporary files of downloads are assumed to
+// be left behind by a crashed run, rather than being written by a concurrent one
+const staleTmpAge = 10 * time.Minute
+
+// removeStaleTmpFiles deletes temporary files of downloads left behind in dir by
+// crashed runs
+func (s *Spotlight) removeStaleTmpFiles(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("read dir: %w", err)
+	}
+
+	var removed int
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), s.prefix) || !strings.HasSuffix(entry.Name(), tmpSuffix) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("get file info: %w", err)
+		}
+
+		if time.Since(info.ModTime()) < staleTmpAge {
+			continue
+		}
+
+		if err := os.Remove(path.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("delete temporary file: %w", err)
+		}
+
+		removed++
+	}
+
+	s.log.Debug("removed stale temporary files", "count", removed)
+
+	return nil
+}
+
+// imageDirs returns the directories images are saved to. With per-source
+// directories, these are the image directory and a subdirectory for each source
+func (s *Spotlight) imageDirs() []string {
+	dirs := []string{filepath.Clean(s.dir)}
+	if s.perSourceDir {
+		for _, name := range s.sourceNames {
+			dirs = append(dirs, s.imageDir(name))
+		}
+	}
+
+	return dirs
+}
+
+// imageDir returns the directory images from the named source are saved to
+func (s *Spotlight) imageDir(source string) string {
+	if s.perSourceDir {
+		return path.Join(s.dir, source)
+	}
+
+	return s.dir
+}
+
+// inImageDir reports whether the file at path is a managed image in one of the
+// image directories
+func (s *Spotlight) inImageDir(file string) bool {
+	return slices.Contains(s.imageDirs(), filepath.Dir(file)) && s.isManaged(filepath.Base(file))
+}
+
+// managedImages returns the managed images in all image directories, sorted oldest
+// first. Missing per-source directories are skipped
+func (s *Spotlight) managedImages() ([]managedImage, error) {
+	var files []managedImage
+	for i, dir := range s.imageDirs() {
+		images, err := s.managedImagesIn(dir)
+		if i > 0 && errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, images...)
+	}
+
+	slices.SortFunc(files, func(a, b managedImage) int {
+		return a.ModTime().Compare(b.ModTime())
+	})
+
+	return files, nil
+}
+
+// managedImagesIn returns the managed images in dir, sorted oldest first
+func (s *Spotlight) managedImagesIn(dir string) ([]managedImage, error) {
+	entries, err := s.managedEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.managedImagesOf(dir, entries)
+}
+
+// managedEntries returns the directory entries of the managed images in dir, in
+// no particular order. Unlike their file info, this doesn't require a stat per
+// image
+func (s *Spotlight) managedEntries(dir string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read dir: %w", err)
+	}
+
+	return slices.DeleteFunc(entries, func(entry os.DirEntry) bool {
+		return entry.IsDir() || !s.isManaged(entry.Name())
+	}), nil
+}
+
+// managedImagesOf returns the managed images of entries in dir, sorted oldest
+// first
+func (s *Spotlight) managedImagesOf(dir string, entries []os.DirEntry) ([]managedImage, error) {
+	files := make([]managedImage, 0, len(entries))
+	for _, entry := range entries {
+		s.log.Debug("found managed image", "value", entry.Name())
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("get file info: %w", err)
+		}
+
+		files = append(files, managedImage{FileInfo: info, path: path.Join(dir, entry.Name())})
+	}
+
+	slices.SortFunc(files, func(a, b managedImage) int {
+		return a.ModTime().Compare(b.ModTime())
+	})
+
+	return files, nil
+}
+
+// isManaged reports whether the file name is matched by the include pattern and
+// not by the exclude pattern. Patterns are validated on startup, so match errors
+// are ignored here. Thumbnails and incomplete downloads are never considered
+// managed images
+func (s *Spotlight) isManaged(name string) bool {
+	if strings.HasSuffix(name, thumbnailSuffix) || strings.HasSuffix(name, tmpSuffix) {
+		return false
+	}
+
+	if ok, _ := filepath.Match(s.includePattern, name); !ok {
+		return false
+	}
+
+	if s.excludePattern != "" {
+		if ok, _ := filepath.Match(s.excludePattern, name); ok {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,50 @@
+package spotlight
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkCleanImagesIn measures the directory scan of cleanImagesIn over n
+// managed images, both when they are within the preserve threshold and when their
+// file info has to be gathered to pick the ones to delete
+func BenchmarkCleanImagesIn(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		dir := b.TempDir()
+		for i := range n {
+			name := filepath.Join(dir, fmt.Sprintf("%s%d.jpg", DefaultPrefix, i))
+			if err := os.WriteFile(name, nil, 0o644); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		s := &Spotlight{
+			log:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+			dir:            dir,
+			prefix:         DefaultPrefix,
+			includePattern: DefaultPrefix + "*",
+			// nothing is deleted, so that each iteration scans all images
+			cleanDryRun: true,
+		}
+
+		b.Run(fmt.Sprintf("within-preserve/%d", n), func(b *testing.B) {
+			for range b.N {
+				if _, err := s.cleanImagesIn(uint(n), ""); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("over-preserve/%d", n), func(b *testing.B) {
+			for range b.N {
+				if _, err := s.cleanImagesIn(uint(n/2), ""); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
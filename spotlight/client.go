@@ -0,0 +1,178 @@
+package spotlight
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/eric-carlsson/gnome-spotlight/api"
+)
+
+// errNoNetwork is returned when connecting while network access is disabled
+var errNoNetwork = errors.New("network access is disabled")
+
+// maxRedirects is the number of redirects followed before a request fails, as for
+// the default client
+const maxRedirects = 10
+
+// proxyFunc selects the proxy for a request, as used by http.Transport
+type proxyFunc func(*http.Request) (*url.URL, error)
+
+// newTLSConfig returns the TLS configuration with the client certificate and CA
+// certificate from opts, if any are set. Otherwise it returns nil
+func newTLSConfig(opts Options) (*tls.Config, error) {
+	if opts.ClientCert == "" && opts.ClientKey == "" && opts.CACert == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		if opts.ClientCert == "" || opts.ClientKey == "" {
+			return nil, errors.New("client certificate and key must be set together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.CACert != "" {
+		pem, err := os.ReadFile(opts.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("read ca certificate: %w", err)
+		}
+
+		// the CA is trusted in addition to the system roots
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CACert)
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
+
+// checkRedirect returns a redirect policy applying check to the URL of every
+// redirect before following it, so that a disallowed redirect is never requested
+func checkRedirect(check func(*url.URL) error) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+
+		if err := check(req.URL); err != nil {
+			return fmt.Errorf("redirect to %s: %w", api.RedactURL(req.URL.String()), err)
+		}
+
+		return nil
+	}
+}
+
+// withoutCredentials wraps the redirect policy next so that the Authorization
+// header and the headers named in headers are dropped from redirects to another
+// host. Custom credential headers are not known to be sensitive by net/http and
+// would be forwarded otherwise
+func withoutCredentials(headers http.Header, next func(*http.Request, []*http.Request) error) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if err := next(req, via); err != nil {
+			return err
+		}
+
+		if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+			req.Header.Del("Authorization")
+			for key := range headers {
+				req.Header.Del(key)
+			}
+		}
+
+		return nil
+	}
+}
+
+// newClient returns an HTTP client using proxy, or no proxy if proxy is nil, the
+// TLS configuration tlsConfig and the connect timeout and keep-alive period from
+// opts. Redirects are followed according to policy
+func newClient(proxy proxyFunc, tlsConfig *tls.Config, opts Options, policy func(*http.Request, []*http.Request) error) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   opts.ConnectTimeout,
+		KeepAlive: opts.KeepAlive,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.DialContext = dialer.DialContext
+	if opts.NoNetwork {
+		transport.Proxy = nil
+		transport.DialContext = func(context.Context, string, string) (net.Conn, error) {
+			return nil, errNoNetwork
+		}
+	}
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{Transport: transport, CheckRedirect: policy}
+}
+
+// newClients returns the clients used for API requests and image downloads. Both
+// use the proxy from opts, falling back to the proxy environment variables,
+// unless the proxy is scoped to only one of them. Both use the same TLS
+// configuration
+func newClients(opts Options) (apiClient, imageClient *http.Client, err error) {
+	if opts.ProxyAPIOnly && opts.ProxyImageOnly {
+		return nil, nil, errors.New("proxy can't be scoped to both api and image only")
+	}
+
+	var proxy proxyFunc = http.ProxyFromEnvironment
+	if opts.Proxy != "" {
+		u, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parse proxy url: %w", err)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	apiProxy, imageProxy := proxy, proxy
+	if opts.ProxyAPIOnly {
+		imageProxy = nil
+	}
+	if opts.ProxyImageOnly {
+		apiProxy = nil
+	}
+
+	tlsConfig, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	checkScheme := func(u *url.URL) error {
+		_, err := api.CheckScheme(u.String(), opts.AllowHTTP)
+		return err
+	}
+
+	// images are also limited to the allowed hosts, which opts holds normalized
+	checkImage := func(u *url.URL) error {
+		if err := checkScheme(u); err != nil {
+			return err
+		}
+		return checkAllowedHost(opts.AllowedHosts, u)
+	}
+
+	apiPolicy := withoutCredentials(opts.Headers, checkRedirect(checkScheme))
+
+	return newClient(apiProxy, tlsConfig, opts, apiPolicy), newClient(imageProxy, tlsConfig, opts, checkRedirect(checkImage)), nil
+}
@@ -0,0 +1,87 @@
+package spotlight
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestWithoutCredentials(t *testing.T) {
+	headers := http.Header{"X-Api-Key": {"secret"}}
+	allow := func(*url.URL) error { return nil }
+	policy := withoutCredentials(headers, checkRedirect(allow))
+
+	tests := []struct {
+		name string
+		from string
+		to   string
+		kept bool
+	}{
+		{"same host", "https://api.example/a", "https://api.example/b", true},
+		{"same host in other case", "https://api.example/a", "https://API.example/b", true},
+		{"other host", "https://api.example/a", "https://cdn.example/b", false},
+		{"other port", "https://api.example/a", "https://api.example:8443/b", false},
+		{"subdomain", "https://api.example/a", "https://evil.api.example/b", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			via, _ := http.NewRequest(http.MethodGet, tt.from, nil)
+			req, _ := http.NewRequest(http.MethodGet, tt.to, nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			req.Header.Set("X-Api-Key", "secret")
+			req.Header.Set("Accept", "application/json")
+
+			if err := policy(req, []*http.Request{via}); err != nil {
+				t.Fatalf("policy: %v", err)
+			}
+
+			for _, key := range []string{"Authorization", "X-Api-Key"} {
+				if kept := req.Header.Get(key) != ""; kept != tt.kept {
+					t.Errorf("%s kept = %v, want %v", key, kept, tt.kept)
+				}
+			}
+			if req.Header.Get("Accept") == "" {
+				t.Error("Accept dropped, want other headers kept")
+			}
+		})
+	}
+}
+
+func TestWithoutCredentialsRedirect(t *testing.T) {
+	var got http.Header
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer target.Close()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusFound)
+	}))
+	defer origin.Close()
+
+	headers := http.Header{"X-Api-Key": {"secret"}}
+	client := &http.Client{
+		CheckRedirect: withoutCredentials(headers, checkRedirect(func(*url.URL) error { return nil })),
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, origin.URL, nil)
+	req.Header.Set("X-Api-Key", "secret")
+	req.Header.Set("Authorization", "Bearer secret")
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if got == nil {
+		t.Fatal("redirect was not followed")
+	}
+	for _, key := range []string{"Authorization", "X-Api-Key"} {
+		if got.Get(key) != "" {
+			t.Errorf("%s sent to other host after redirect", key)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package spotlight
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// copiesFile is the name of the state file recording the hashes of the copies
+// written to the copy directory, so that earlier copies can be told apart from
+// files put there by others
+const copiesFile = "copies.json"
+
+// copyImage copies the image at path to the copy directory. A file already at the
+// destination is kept if it has the same content, and only replaced if it is an
+// unmodified earlier copy or replacing unmanaged files is allowed
+func (s *Spotlight) copyImage(path string) error {
+	if err := mkdirAll(s.copyTo, s.dirMode); err != nil {
+		return fmt.Errorf("create copy dir: %w", err)
+	}
+
+	dst := filepath.Join(s.copyTo, filepath.Base(path))
+
+	sum, err := hashFile(path)
+	if err != nil {
+		return fmt.Errorf("hash image: %w", err)
+	}
+
+	copies, err := s.loadCopies()
+	if err != nil {
+		return err
+	}
+
+	existing, err := hashFile(dst)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("hash existing copy: %w", err)
+	case existing == sum:
+		s.log.Debug("copy is up to date", "path", dst)
+		return nil
+	case existing != copies[dst] && !s.overwrite:
+		return fmt.Errorf("refusing to replace existing file %s with copy", dst)
+	}
+
+	if err := copyFile(path, dst); err != nil {
+		return fmt.Errorf("copy image: %w", err)
+	}
+
+	s.log.Info("copied image", "path", dst)
+
+	copies[dst] = sum
+
+	data, err := json.MarshalIndent(copies, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode copies: %w", err)
+	}
+
+	if err := s.writeState(copiesFile, data); err != nil {
+		return fmt.Errorf("write copies file: %w", err)
+	}
+
+	return nil
+}
+
+// loadCopies reads the copies file, mapping the paths of copies to the hashes of
+// their content, returning an empty map if it doesn't exist
+func (s *Spotlight) loadCopies() (map[string]string, error) {
+	data, err := s.readState(copiesFile)
+	if err != nil {
+		return nil, fmt.Errorf("read copies file: %w", err)
+	}
+
+	copies := map[string]string{}
+	if data == nil {
+		return copies, nil
+	}
+
+	if err := json.Unmarshal(data, &copies); err != nil {
+		return nil, fmt.Errorf("decode copies file: %w", err)
+	}
+
+	return copies, nil
+}
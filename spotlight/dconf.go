@@ -0,0 +1,351 @@
+package spotlight
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	backgroundKey     = "/org/gnome/desktop/background/picture-uri"
+	backgroundDarkKey = "/org/gnome/desktop/background/picture-uri-dark"
+	screensaverKey    = "/org/gnome/desktop/screensaver/picture-uri"
+	primaryColorKey   = "/org/gnome/desktop/background/primary-color"
+	secondaryColorKey = "/org/gnome/desktop/background/secondary-color"
+	colorSchemeKey    = "/org/gnome/desktop/interface/color-scheme"
+)
+
+// command runs the command name with args and returns its output. The command is
+// killed if it doesn't complete within the D-Bus timeout, e.g. because the session
+// bus or the service behind it is stuck
+func (s *Spotlight) command(ctx context.Context, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.dbusTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	// don't wait for children of the killed command holding on to its output
+	cmd.WaitDelay = time.Second
+	if s.sessionBus != "" {
+		cmd.Env = append(os.Environ(), "DBUS_SESSION_BUS_ADDRESS="+s.sessionBus)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("execute %s %s: timed out after %s", name, args[0], s.dbusTimeout)
+		}
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("execute %s %s: %w: %s", name, args[0], err, bytes.TrimSpace(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("execute %s %s: %w", name, args[0], err)
+	}
+
+	return out, nil
+}
+
+// dconf runs the dconf command with args and returns its output, see command
+func (s *Spotlight) dconf(ctx context.Context, args ...string) (string, error) {
+	out, err := s.command(ctx, "dconf", args...)
+	return string(out), err
+}
+
+// writeKey writes value to the dconf key, retrying failed writes up to the backend
+// retry count, see MaxBackendRetries
+func (s *Spotlight) writeKey(ctx context.Context, key, value string) error {
+	_, err := s.dconf(ctx, "write", key, value)
+	for attempt := uint(1); err != nil && attempt <= s.backendRetries && ctx.Err() == nil; attempt++ {
+		s.log.Warn("failed to write dconf entry, retrying", "key", key, "attempt", attempt, "error", err)
+		_, err = s.dconf(ctx, "write", key, value)
+	}
+
+	return err
+}
+
+// ensureSessionBus makes sure the session bus address is set for the dconf
+// commands, which need it to write. When run outside the graphical session, e.g.
+// from cron or over SSH, DBUS_SESSION_BUS_ADDRESS is usually unset, in which case
+// the bus of the running user session is passed to the commands run afterwards.
+// The environment of the process is left alone
+func (s *Spotlight) ensureSessionBus() error {
+	if addr := os.Getenv("DBUS_SESSION_BUS_ADDRESS"); addr != "" {
+		s.log.Debug("using session bus from environment", "value", addr)
+		return nil
+	}
+
+	if s.sessionBus != "" {
+		return nil
+	}
+
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+
+	bus := path.Join(runtimeDir, "bus")
+
+	info, err := os.Stat(bus)
+	if err != nil || info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf(
+			"DBUS_SESSION_BUS_ADDRESS is not set and no session bus was found at %s. "+
+				"Make sure the user is logged in to a graphical session, or set "+
+				"DBUS_SESSION_BUS_ADDRESS to the address of its session bus",
+			bus,
+		)
+	}
+
+	addr := "unix:path=" + bus
+	s.sessionBus = addr
+
+	s.log.Info("DBUS_SESSION_BUS_ADDRESS not set, using discovered session bus", "value", addr)
+
+	return nil
+}
+
+// writeToDconf sets dconf entries for background image to imagePath
+func (s *Spotlight) writeToDconf(ctx context.Context, imagePath string) error {
+	if err := s.ensureSessionBus(); err != nil {
+		return err
+	}
+
+	var keys []string
+	if s.which != "dark" {
+		keys = append(keys, backgroundKey, screensaverKey)
+	}
+	if s.which != "light" {
+		keys = append(keys, backgroundDarkKey)
+	}
+
+	// previous values of the written keys, for rolling back. Empty values are
+	// keys that were unset
+	previous := map[string]string{}
+
+	var written, failed []string
+	var errs []error
+	for _, key := range keys {
+		exists, err := s.keyExists(ctx, key)
+		if err != nil {
+			failed, errs = append(failed, key), append(errs, fmt.Errorf("%s: %w", key, err))
+			if s.atomicKeys {
+				break
+			}
+			continue
+		}
+		if !exists {
+			s.log.Debug("skipping dconf entry without schema", "key", key)
+			continue
+		}
+
+		if s.atomicKeys {
+			out, err := s.dconf(ctx, "read", key)
+			if err != nil {
+				failed, errs = append(failed, key), append(errs, fmt.Errorf("%s: %w", key, err))
+				break
+			}
+			previous[key] = strings.TrimSpace(out)
+		}
+
+		// note quotes, this is necessary for dconf to recognize value as string
+		value := fmt.Sprintf("'%s'", fileURI(imagePath))
+
+		s.log.Info("writing dconf entry", "key", key, "value", value)
+
+		if err := s.writeKey(ctx, key, value); err != nil {
+			failed, errs = append(failed, key), append(errs, fmt.Errorf("%s: %w", key, err))
+			if s.atomicKeys {
+				break
+			}
+			continue
+		}
+
+		written = append(written, key)
+	}
+
+	if len(failed) == 0 {
+		if len(written) == 0 {
+			return fmt.Errorf("none of %s exist", strings.Join(keys, ", "))
+		}
+		return nil
+	}
+
+	if s.atomicKeys {
+		s.rollbackDconf(ctx, written, previous)
+	} else if len(written) > 0 {
+		s.log.Warn("background was only partially applied, desktop may be in a mixed state", "written", written, "failed", failed)
+	}
+
+	return fmt.Errorf("failed to write %s: %w", strings.Join(failed, ", "), errors.Join(errs...))
+}
+
+// fileURI returns the file URI of path, with characters such as spaces, # and ?
+// percent-encoded. Single quotes are encoded as well, as they would end the quoted
+// dconf string
+func fileURI(path string) string {
+	u := url.URL{Scheme: "file", Path: path}
+	return strings.ReplaceAll(u.String(), "'", "%27")
+}
+
+// rollbackDconf restores the keys to their previous values, resetting keys that
+// were unset. This is best-effort, failures are logged
+func (s *Spotlight) rollbackDconf(ctx context.Context, keys []string, previous map[string]string) {
+	for _, key := range keys {
+		var err error
+		if value := previous[key]; value == "" {
+			_, err = s.dconf(ctx, "reset", key)
+		} else {
+			_, err = s.dconf(ctx, "write", key, value)
+		}
+
+		if err != nil {
+			s.log.Warn("failed to roll back dconf entry", "key", key, "error", err)
+			continue
+		}
+
+		s.log.Info("rolled back dconf entry", "key", key, "value", previous[key])
+	}
+}
+
+// keyExists reports whether the schema of the dconf key exists and contains the
+// key. Newer GNOME versions dropped some keys, e.g. the screensaver picture-uri.
+// If gsettings isn't installed or has no schemas, the key is assumed to exist.
+// Other failures of gsettings, such as timeouts, are returned
+func (s *Spotlight) keyExists(ctx context.Context, key string) (bool, error) {
+	dir, name := path.Split(key)
+	schema := strings.ReplaceAll(strings.Trim(dir, "/"), "/", ".")
+
+	out, err := s.command(ctx, "gsettings", "list-keys", schema)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && strings.Contains(string(exitErr.Stderr), "No such schema") {
+			return false, nil
+		}
+
+		// without gsettings or any schemas, it can't tell
+		if errors.Is(err, exec.ErrNotFound) || errors.As(err, &exitErr) && strings.Contains(string(exitErr.Stderr), "No schemas installed") {
+			s.log.Debug("failed to list schema keys, assuming key exists", "schema", schema, "error", err)
+			return true, nil
+		}
+
+		return false, err
+	}
+
+	return slices.Contains(strings.Fields(string(out)), name), nil
+}
+
+// writeColors sets the background colors shown around images that don't fill the
+// screen
+func (s *Spotlight) writeColors(ctx context.Context, primary, secondary string) error {
+	for key, value := range map[string]string{primaryColorKey: primary, secondaryColorKey: secondary} {
+		// note quotes, this is necessary for dconf to recognize value as string
+		value = fmt.Sprintf("'%s'", value)
+
+		s.log.Info("writing dconf entry", "key", key, "value", value)
+
+		if err := s.writeKey(ctx, key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeColorScheme sets the interface color scheme to dark if the brightness of the
+// background, on a scale of 0 to 255, is below darkThreshold and to the default
+// otherwise. The key only exists since GNOME 42, on older versions nothing is
+// written
+func (s *Spotlight) writeColorScheme(ctx context.Context, brightness float64) error {
+	exists, err := s.keyExists(ctx, colorSchemeKey)
+	if err != nil {
+		return fmt.Errorf("%s: %w", colorSchemeKey, err)
+	}
+	if !exists {
+		s.log.Debug("skipping dconf entry without schema", "key", colorSchemeKey)
+		return nil
+	}
+
+	scheme := "default"
+	if brightness < darkThreshold {
+		scheme = "prefer-dark"
+	}
+
+	// note quotes, this is necessary for dconf to recognize value as string
+	value := fmt.Sprintf("'%s'", scheme)
+
+	s.log.Info("writing dconf entry", "key", colorSchemeKey, "value", value, "brightness", fmt.Sprintf("%.1f", brightness))
+
+	return s.writeKey(ctx, colorSchemeKey, value)
+}
+
+// currentImage returns the path of the image the background is currently set to.
+// If the dark variant is managed exclusively, its key is read instead. If the
+// background is unset or set to none, i.e. a plain color, the path is empty
+func (s *Spotlight) currentImage(ctx context.Context) (string, error) {
+	key := backgroundKey
+	if s.which == "dark" {
+		key = backgroundDarkKey
+	}
+
+	out, err := s.dconf(ctx, "read", key)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := filePath(out)
+	if err != nil {
+		return "", fmt.Errorf("parse %s: %w", key, err)
+	}
+
+	return path, nil
+}
+
+// filePath returns the path of the file URI in the dconf value, as written by
+// fileURI, or an empty path if the value is unset
+func filePath(value string) (string, error) {
+	value = strings.Trim(strings.TrimSpace(value), "'")
+	if value == "" || value == "none" {
+		return "", nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("not a file uri: %s", value)
+	}
+
+	return u.Path, nil
+}
+
+// Dump writes the current values of the background and screensaver keys to w
+func (s *Spotlight) Dump(ctx context.Context, w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, key := range []string{backgroundKey, backgroundDarkKey, screensaverKey} {
+		value, err := s.dconf(ctx, "read", key)
+		if err != nil {
+			return err
+		}
+
+		value = strings.TrimSpace(value)
+		if value == "" {
+			value = "(unset)"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\n", key, value)
+	}
+
+	return tw.Flush()
+}
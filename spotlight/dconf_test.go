@@ -0,0 +1,35 @@
+package spotlight
+
+import "testing"
+
+func TestFileURI(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/usr/share/backgrounds/a.jpg", "file:///usr/share/backgrounds/a.jpg"},
+		{"/home/me/My Backgrounds/a.jpg", "file:///home/me/My%20Backgrounds/a.jpg"},
+		{"/home/me/#1/a.jpg", "file:///home/me/%231/a.jpg"},
+		{"/home/me/why?/a.jpg", "file:///home/me/why%3F/a.jpg"},
+		{"/home/me/it's/a.jpg", "file:///home/me/it%27s/a.jpg"},
+		{"/home/me/Hintergründe/a.jpg", "file:///home/me/Hintergr%C3%BCnde/a.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := fileURI(tt.path)
+			if got != tt.want {
+				t.Errorf("fileURI(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+
+			// the value read back by currentImage is quoted like the one written
+			path, err := filePath("'" + got + "'\n")
+			if err != nil {
+				t.Fatalf("filePath(%q): %v", got, err)
+			}
+			if path != tt.path {
+				t.Errorf("filePath(%q) = %q, want %q", got, path, tt.path)
+			}
+		})
+	}
+}
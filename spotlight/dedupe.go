@@ -0,0 +1,122 @@
+package spotlight
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Dedupe deletes managed images with identical content, keeping the newest image
+// of each group of duplicates, and writes a summary to w. The image currently set
+// as background is always kept instead, so the background stays valid. With
+// perceptual deduplication, visually identical images count as duplicates as well
+func (s *Spotlight) Dedupe(ctx context.Context, w io.Writer) error {
+	files, err := s.managedImages()
+	if err != nil {
+		return fmt.Errorf("list managed images: %w", err)
+	}
+
+	// the current image goes last, so that it is the first of its group visited
+	if background, err := s.currentImage(ctx); err != nil {
+		s.log.Warn("failed to get current background, it may be deleted as a duplicate", "error", err)
+	} else if i := slices.IndexFunc(files, func(f managedImage) bool { return f.path == filepath.Clean(background) }); i != -1 {
+		file := files[i]
+		files = append(slices.Delete(files, i, i+1), file)
+	}
+
+	index, err := s.loadIndex()
+	if err != nil {
+		return fmt.Errorf("load index: %w", err)
+	}
+
+	var deleted int
+	var reclaimed int64
+	seen := map[string]string{}
+	// perceptual hashes of the kept images, by name
+	kept := map[string]uint64{}
+
+	// newest first, so that the newest image of each group is kept
+	for i := len(files) - 1; i >= 0; i-- {
+		file := files[i]
+		name := file.path
+
+		sum, err := hashFile(name)
+		if err != nil {
+			return fmt.Errorf("hash image: %w", err)
+		}
+
+		duplicateOf, ok := seen[sum]
+		if !ok && s.phash {
+			duplicateOf, ok, err = s.similarKept(index, file, kept)
+			if err != nil {
+				return err
+			}
+		}
+		if !ok {
+			seen[sum] = file.Name()
+			continue
+		}
+
+		s.log.Info("deleting duplicate image", "value", file.Name(), "duplicate_of", duplicateOf)
+
+		if err := os.Remove(name); err != nil {
+			return fmt.Errorf("delete image: %w", err)
+		}
+
+		if err := removeThumbnail(name); err != nil {
+			return fmt.Errorf("delete thumbnail: %w", err)
+		}
+
+		delete(index, file.Name())
+		deleted++
+		reclaimed += file.Size()
+	}
+
+	if err := s.saveIndex(index); err != nil {
+		return fmt.Errorf("save index: %w", err)
+	}
+
+	fmt.Fprintf(w, "deleted %d duplicate images, reclaimed %d bytes\n", deleted, reclaimed)
+
+	return nil
+}
+
+// similarKept returns the name of the image among kept which file is visually
+// identical to, and whether there is one. If there is none, file is added to kept
+func (s *Spotlight) similarKept(idx index, file managedImage, kept map[string]uint64) (string, bool, error) {
+	hash, err := s.perceptualHash(idx, file)
+	if err != nil {
+		return "", false, fmt.Errorf("hash image: %w", err)
+	}
+
+	for name, other := range kept {
+		if s.similar(hash, other) {
+			return name, true, nil
+		}
+	}
+
+	kept[file.Name()] = hash
+
+	return "", false, nil
+}
+
+// hashFile returns the hex encoded SHA-256 hash of the file at path
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
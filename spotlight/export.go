@@ -0,0 +1,98 @@
+package spotlight
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// exportImageDir is the directory of the images in exported archives
+const exportImageDir = "images"
+
+// Export writes a gzip compressed tar archive of the managed images and their
+// index entries to w. Images are stored below images/, by their path relative to
+// the image directory, and the index entries in index.json
+func (s *Spotlight) Export(w io.Writer) error {
+	files, err := s.managedImages()
+	if err != nil {
+		return fmt.Errorf("list managed images: %w", err)
+	}
+
+	idx, err := s.loadIndex()
+	if err != nil {
+		return fmt.Errorf("load index: %w", err)
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	exported := index{}
+	for _, file := range files {
+		rel, err := filepath.Rel(s.dir, file.path)
+		if err != nil {
+			return fmt.Errorf("relative image path: %w", err)
+		}
+
+		if err := addFile(tw, path.Join(exportImageDir, filepath.ToSlash(rel)), file.path, file.FileInfo); err != nil {
+			return fmt.Errorf("add image %s: %w", file.Name(), err)
+		}
+
+		if entry, ok := idx[file.Name()]; ok {
+			exported[file.Name()] = entry
+		}
+
+		s.log.Debug("exported image", "value", rel)
+	}
+
+	data, err := json.MarshalIndent(exported, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode index: %w", err)
+	}
+
+	header := &tar.Header{Name: indexFile, Mode: 0o644, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("add index: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("add index: %w", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("close archive: %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return fmt.Errorf("close archive: %w", err)
+	}
+
+	s.log.Info("exported images", "count", len(files))
+
+	return nil
+}
+
+// addFile adds the file at path, described by info, to tw under name
+func addFile(tw *tar.Writer, name, path string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(tw, file)
+	return err
+}
@@ -0,0 +1,497 @@
+package spotlight
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/eric-carlsson/gnome-spotlight/api"
+)
+
+// imageName derives the file name of an image from its URL. If the URL path has no
+// usable base name, or the image is identified by query parameters rather than
+// the path, a short hash of the URL is appended to keep names distinct
+func imageName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return hashName("image", "", rawURL)
+	}
+
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		return hashName("image", "", rawURL)
+	}
+
+	ext := path.Ext(name)
+	if ext == "" || u.RawQuery != "" {
+		return hashName(strings.TrimSuffix(name, ext), ext, rawURL)
+	}
+
+	return name
+}
+
+// hashName returns stem and ext joined by a short hash of rawURL
+func hashName(stem, ext, rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return fmt.Sprintf("%s_%s%s", stem, hex.EncodeToString(sum[:4]), ext)
+}
+
+// checkHost returns an error if the host of rawURL is not in the allowed hosts, or
+// if rawURL is not an HTTPS URL unless plain HTTP is allowed
+func (s *Spotlight) checkHost(rawURL string) error {
+	insecure, err := api.CheckScheme(rawURL, s.allowHTTP)
+	if err != nil {
+		return fmt.Errorf("image %w", err)
+	}
+
+	if insecure {
+		s.log.Warn("downloading image over plain http", "url", api.RedactURL(rawURL))
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("parse image url: %w", err)
+	}
+
+	return checkAllowedHost(s.allowedHosts, u)
+}
+
+// checkAllowedHost returns an error if the host of u is not one of hosts or a
+// subdomain of one. Empty hosts allow all
+func checkAllowedHost(hosts []string, u *url.URL) error {
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range hosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("image host %q is not allowed", host)
+}
+
+// allowedHosts returns hosts trimmed and lowercased, without empty entries
+func allowedHosts(hosts []string) []string {
+	var allowed []string
+	for _, host := range hosts {
+		if host = strings.TrimSpace(host); host != "" {
+			allowed = append(allowed, strings.ToLower(host))
+		}
+	}
+
+	return allowed
+}
+
+// orderedSources returns the sources in the order they are tried. With source
+// weights, the first source is picked randomly according to its weight
+func (s *Spotlight) orderedSources() []api.API {
+	if len(s.weights) == 0 {
+		return s.sources
+	}
+
+	var total uint
+	for _, weight := range s.weights {
+		total += weight
+	}
+
+	pick := uint(s.rand.UintN(total))
+	for i, weight := range s.weights {
+		if pick < weight {
+			s.log.Debug("picked source by weight", "source", s.sources[i].Name(), "weight", weight, "total", total)
+			return append([]api.API{s.sources[i]}, slices.Delete(slices.Clone(s.sources), i, i+1)...)
+		}
+		pick -= weight
+	}
+
+	return s.sources
+}
+
+// probeImage checks that the image at rawURL is available with a HEAD request,
+// so that dead links are detected before downloading. Servers not supporting HEAD
+// requests pass the check
+func (s *Spotlight) probeImage(ctx context.Context, rawURL string) error {
+	if err := s.checkHost(rawURL); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, nil)
+	if err != nil {
+		return fmt.Errorf("build probe request: %w", err)
+	}
+
+	res, err := s.imageClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("probe image: %w", err)
+	}
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusMethodNotAllowed || res.StatusCode == http.StatusNotImplemented:
+		s.log.Debug("image server doesn't support probing", "status", res.StatusCode)
+		return nil
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("received non-ok response code when probing image: %d", res.StatusCode)
+	case res.ContentLength == 0:
+		return errors.New("probed image is empty")
+	}
+
+	if contentType := res.Header.Get("Content-Type"); contentType != "" && !strings.HasPrefix(contentType, "image/") {
+		return fmt.Errorf("probed image has content type %s", contentType)
+	}
+
+	s.log.Debug("probed image", "bytes", res.ContentLength)
+
+	return nil
+}
+
+// resolve gets an image from the first source that yields one, trying the
+// fallback sources in order if the primary source fails
+func (s *Spotlight) resolve(ctx context.Context) (api.API, api.Image, error) {
+	var errs []error
+	for i, source := range s.orderedSources() {
+		if i > 0 {
+			s.log.Info("trying fallback source", "source", source.Name())
+		}
+
+		image, err := source.Get(ctx)
+		if err == nil && image.URL == "" {
+			err = errors.New("no image url")
+		}
+		if err == nil {
+			if keyword := s.excludedKeyword(image); keyword != "" {
+				err = fmt.Errorf("image %q matches excluded keyword %q", image.Title, keyword)
+			} else if !s.included(image) {
+				err = fmt.Errorf("image %q matches none of the include keywords", image.Title)
+			}
+		}
+		if err == nil && s.dedupeSeen {
+			var downloaded bool
+			var t time.Time
+			if downloaded, t, err = s.seenBefore(image.URL); err == nil && downloaded {
+				err = fmt.Errorf("image %q was already downloaded at %s", image.Title, t.Format(time.RFC3339))
+			}
+		}
+		if err == nil && s.probe {
+			err = s.probeImage(ctx, image.URL)
+		}
+		if err != nil {
+			s.log.Warn("failed to get image from source", "source", source.Name(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", source.Name(), err))
+			continue
+		}
+
+		s.log.Info("resolved image", "source", source.Name())
+
+		return source, image, nil
+	}
+
+	return nil, api.Image{}, errors.Join(errs...)
+}
+
+// Resolve returns the image the sources currently offer, without downloading it
+func (s *Spotlight) Resolve(ctx context.Context) (api.Image, error) {
+	_, image, err := s.resolve(ctx)
+	return image, err
+}
+
+// checkExists returns ErrImageExists if an image exists at path, unless existing
+// images are replaced. Files that are not managed images are never replaced,
+// unless explicitly allowed
+func (s *Spotlight) checkExists(path string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if !s.force {
+		return ErrImageExists
+	}
+
+	if !s.isManaged(filepath.Base(path)) && !s.overwrite {
+		return fmt.Errorf("refusing to replace %s, which is not a managed image", path)
+	}
+
+	s.log.Info("image already exists, replacing it", "path", path)
+
+	return nil
+}
+
+// Fetch downloads a new image from the sources into the image directory
+func (s *Spotlight) Fetch(ctx context.Context) (Image, error) {
+	image, _, err := s.fetch(ctx)
+	return image, err
+}
+
+// fetch is Fetch, additionally returning the image decoded if any feature needed
+// its content, so that applying it doesn't need to decode it again
+func (s *Spotlight) fetch(ctx context.Context) (Image, decodedImage, error) {
+	source, image, err := s.resolve(ctx)
+	if err != nil {
+		return Image{}, decodedImage{}, fmt.Errorf("error getting image url: %w", err)
+	}
+
+	url := image.URL
+
+	s.log.Info("fetched new image from api", "title", image.Title, "copyright", image.Copyright)
+
+	s.log.Debug("extraced image url from response", "value", api.RedactURL(url))
+
+	if err := s.checkHost(url); err != nil {
+		return Image{}, decodedImage{}, err
+	}
+
+	dir := s.imageDir(source.Name())
+
+	path := path.Join(dir, s.prefix+imageName(url))
+	if s.format != "" {
+		path = strings.TrimSuffix(path, filepath.Ext(path)) + formatExts[s.format]
+	}
+
+	// without force, an existing managed image is only downloaded again if it
+	// changed on the server. With content hash names, the name is only known once
+	// the image is downloaded
+	var existing os.FileInfo
+	if !s.contentNames {
+		if info, err := os.Stat(path); err == nil && !s.force && s.isManaged(filepath.Base(path)) {
+			existing = info
+		} else if err := s.checkExists(path); err != nil {
+			return Image{}, decodedImage{}, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Image{}, decodedImage{}, fmt.Errorf("build image request: %w", err)
+	}
+
+	if existing != nil {
+		req.Header.Set("If-Modified-Since", existing.ModTime().UTC().Format(http.TimeFormat))
+
+		if idx, err := s.loadIndex(); err == nil && idx[filepath.Base(path)].ETag != "" {
+			req.Header.Set("If-None-Match", idx[filepath.Base(path)].ETag)
+		}
+	}
+
+	res, err := s.imageClient.Do(req)
+	if err != nil {
+		return Image{}, decodedImage{}, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	defer res.Body.Close()
+
+	// the request may have been redirected to another host
+	if err := s.checkHost(res.Request.URL.String()); err != nil {
+		return Image{}, decodedImage{}, fmt.Errorf("after redirect: %w", err)
+	}
+
+	if res.StatusCode == http.StatusNotModified && existing != nil {
+		s.log.Info("image unchanged on server, keeping existing file", "path", path)
+		return Image{}, decodedImage{}, ErrImageExists
+	}
+
+	if existing != nil && res.StatusCode == http.StatusOK {
+		s.log.Info("image changed on server, replacing it", "path", path)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return Image{}, decodedImage{}, fmt.Errorf("received non-ok response code when fetching image: %d", res.StatusCode)
+	}
+
+	s.log.Info("downloaded image")
+
+	info, err := os.Stat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := mkdirAll(dir, s.dirMode); err != nil {
+			return Image{}, decodedImage{}, fmt.Errorf("create image directory: %w", err)
+		}
+
+		s.log.Info("created image directory", "path", dir, "mode", s.dirMode)
+
+		info, err = os.Stat(dir)
+	}
+	if err != nil {
+		return Image{}, decodedImage{}, fmt.Errorf("stat image directory: %w", err)
+	}
+
+	if !info.IsDir() {
+		return Image{}, decodedImage{}, fmt.Errorf("dir exists but is not a directory")
+	}
+
+	if err := s.removeStaleTmpFiles(dir); err != nil {
+		return Image{}, decodedImage{}, fmt.Errorf("remove stale temporary files: %w", err)
+	}
+
+	// the image is written to a temporary file and moved into place once complete,
+	// so that an existing image is replaced atomically and a failed download never
+	// leaves a partial image behind
+	tmpPath := path + tmpSuffix
+
+	file, err := createFile(tmpPath, s.fileMode)
+	if err != nil {
+		return Image{}, decodedImage{}, fmt.Errorf("create image file: %w", err)
+	}
+
+	defer os.Remove(tmpPath)
+	defer file.Close()
+
+	start := time.Now()
+	hash := sha256.New()
+
+	// a body cut short of its Content-Length ends with an unexpected EOF
+	n, err := io.Copy(io.MultiWriter(file, hash), res.Body)
+	if errors.Is(err, io.ErrUnexpectedEOF) && s.shortWarn {
+		s.log.Warn("image is smaller than advertised, it may be incomplete", "bytes", n, "content_length", res.ContentLength)
+	} else if err != nil {
+		return Image{}, decodedImage{}, fmt.Errorf("write image file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return Image{}, decodedImage{}, fmt.Errorf("close image file: %w", err)
+	}
+
+	elapsed := time.Since(start)
+	throughput := float64(n) / 1e6 / elapsed.Seconds()
+
+	s.log.Info("wrote image to file", "bytes", n, "path", path, "duration", elapsed, "mb_per_sec", fmt.Sprintf("%.2f", throughput))
+
+	if s.slowWarn > 0 && throughput < s.slowWarn {
+		s.log.Warn("image download was slow", "mb_per_sec", fmt.Sprintf("%.2f", throughput), "threshold", s.slowWarn)
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	if image.SHA256 != "" {
+		if !strings.EqualFold(sum, image.SHA256) {
+			return Image{}, decodedImage{}, fmt.Errorf("image hash mismatch: expected %s, got %s", image.SHA256, sum)
+		}
+
+		s.log.Debug("verified image hash", "value", image.SHA256)
+	}
+
+	if s.contentNames {
+		path = filepath.Join(dir, s.prefix+sum[:16]+filepath.Ext(path))
+
+		if err := s.checkExists(path); err != nil {
+			return Image{}, decodedImage{}, err
+		}
+
+		s.log.Debug("named image by content hash", "path", path)
+	}
+
+	transform := len(s.transforms) > 0 || s.format != ""
+
+	// the image is decoded once and shared by all features that need its content
+	decoded := decodedImage{path: path}
+	if s.verifyDecode || s.minContrast > 0 || transform || s.thumbnail > 0 || s.colors || s.colorScheme || s.phash {
+		img, format, err := decodeImage(tmpPath)
+		if err != nil {
+			return Image{}, decodedImage{}, fmt.Errorf("verify image: %w", err)
+		}
+
+		s.log.Debug("decoded image", "path", path, "format", format, "width", img.Bounds().Dx(), "height", img.Bounds().Dy())
+
+		if s.minContrast > 0 {
+			c := contrast(img)
+			if c < s.minContrast {
+				return Image{}, decodedImage{}, fmt.Errorf("image contrast %.1f is below minimum %.1f", c, s.minContrast)
+			}
+
+			s.log.Debug("image contrast is sufficient", "value", fmt.Sprintf("%.1f", c))
+		}
+
+		if transform {
+			var encoded string
+			if img, encoded, err = s.transformImage(tmpPath, img, format, image); err != nil {
+				return Image{}, decodedImage{}, fmt.Errorf("transform image: %w", err)
+			}
+
+			// without a configured format, images in a format that can't be encoded
+			// are converted, the extension has to match
+			if s.format == "" && encoded != format {
+				path = strings.TrimSuffix(path, filepath.Ext(path)) + formatExts[encoded]
+				decoded.path = path
+
+				if err := s.checkExists(path); err != nil {
+					return Image{}, decodedImage{}, err
+				}
+			}
+		}
+
+		decoded.img = img
+	}
+
+	var phash string
+	if s.phash {
+		hash := dHash(decoded.img)
+
+		similar, err := s.similarImage(hash, filepath.Base(path))
+		if err != nil {
+			return Image{}, decodedImage{}, fmt.Errorf("compare image: %w", err)
+		}
+
+		if similar != "" {
+			return Image{}, decodedImage{}, fmt.Errorf("%w: visually identical to %s", ErrImageExists, similar)
+		}
+
+		phash = formatPHash(hash)
+	}
+
+	if lastModified, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		if err := os.Chtimes(tmpPath, lastModified, lastModified); err != nil {
+			return Image{}, decodedImage{}, fmt.Errorf("set image modification time: %w", err)
+		}
+
+		s.log.Debug("set image modification time from server", "value", lastModified)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return Image{}, decodedImage{}, fmt.Errorf("move image into place: %w", err)
+	}
+
+	s.logImage(path)
+
+	index, err := s.loadIndex()
+	if err != nil {
+		return Image{}, decodedImage{}, fmt.Errorf("load index: %w", err)
+	}
+
+	index[filepath.Base(path)] = indexEntry{
+		Source:      source.Name(),
+		URL:         url,
+		Title:       image.Title,
+		Copyright:   image.Copyright,
+		Description: image.Description,
+		ETag:        res.Header.Get("ETag"),
+		PHash:       phash,
+	}
+
+	if err := s.saveIndex(index); err != nil {
+		return Image{}, decodedImage{}, fmt.Errorf("save index: %w", err)
+	}
+
+	if s.dedupeSeen {
+		if err := s.markSeen(url); err != nil {
+			return Image{}, decodedImage{}, fmt.Errorf("mark image as seen: %w", err)
+		}
+	}
+
+	if s.thumbnail > 0 {
+		if err := s.writeThumbnail(path, decoded.img, int(s.thumbnail)); err != nil {
+			return Image{}, decodedImage{}, fmt.Errorf("write thumbnail: %w", err)
+		}
+	}
+
+	return Image{Image: image, Path: path, Source: source.Name()}, decoded, nil
+}
@@ -0,0 +1,51 @@
+package spotlight
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestCheckAllowedHost(t *testing.T) {
+	hosts := allowedHosts([]string{" bing.com", "IMG-S-MSN-COM.akamaized.net", ""})
+
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://bing.com/th?id=a.jpg", true},
+		{"https://www.bing.com/th?id=a.jpg", true},
+		{"https://WWW.Bing.Com/th?id=a.jpg", true},
+		{"https://bing.com:8443/a.jpg", true},
+		{"https://img-s-msn-com.akamaized.net/a.jpg", true},
+		{"https://akamaized.net/a.jpg", false},
+		{"https://evilbing.com/a.jpg", false},
+		{"https://bing.com.evil.example/a.jpg", false},
+		{"https://evil.example/bing.com/a.jpg", false},
+		{"https://bing.com@evil.example/a.jpg", false},
+		{"https://evil.example/a.jpg?host=bing.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = checkAllowedHost(hosts, u)
+			if tt.allowed && err != nil {
+				t.Errorf("checkAllowedHost(%q) = %v, want allowed", tt.url, err)
+			}
+			if !tt.allowed && err == nil {
+				t.Errorf("checkAllowedHost(%q) allowed, want rejected", tt.url)
+			}
+		})
+	}
+}
+
+func TestCheckAllowedHostWithoutHosts(t *testing.T) {
+	u, _ := url.Parse("https://anything.example/a.jpg")
+	if err := checkAllowedHost(allowedHosts([]string{"", " "}), u); err != nil {
+		t.Errorf("checkAllowedHost without hosts = %v, want all allowed", err)
+	}
+}
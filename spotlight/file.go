@@ -0,0 +1,88 @@
+package spotlight
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// tmpSuffix is appended to the names of files while they are being written
+const tmpSuffix = ".tmp"
+
+// createFile creates or truncates the file at path with exactly the given
+// permissions, regardless of the umask
+func createFile(path string, mode os.FileMode) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := file.Chmod(mode); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// mkdirAll creates the directory at path and any missing parents. If it is
+// created, it gets exactly the given permissions regardless of the umask
+func mkdirAll(path string, mode os.FileMode) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(path, mode); err != nil {
+		return err
+	}
+
+	return os.Chmod(path, mode)
+}
+
+// moveFile moves the file src to dst, copying it if they are on different file systems
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// copyFile copies the file src to dst, replacing dst if it exists. The permissions
+// and modification time of src are preserved
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
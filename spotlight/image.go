@@ -0,0 +1,268 @@
+package spotlight
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+
+	_ "golang.org/x/image/webp"
+)
+
+// thumbnailSuffix is appended to the file name of an image to name its thumbnail
+const thumbnailSuffix = ".thumb.jpg"
+
+// imageExts are the file extensions of the formats images can be decoded from
+var imageExts = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"webp": ".webp",
+}
+
+// decodedImage is an image file along with its decoded content, which is nil if
+// the file wasn't decoded
+type decodedImage struct {
+	path string
+	img  image.Image
+}
+
+// decodeImage decodes the image file at path, returning the image and the name of
+// its format
+func decodeImage(path string) (image.Image, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("open image: %w", err)
+	}
+	defer file.Close()
+
+	img, format, err := image.Decode(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("decode image: %w", err)
+	}
+
+	return img, format, nil
+}
+
+// logImage logs the format, dimensions and size of the image file at path. It only
+// decodes the image header
+func (s *Spotlight) logImage(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		s.log.Warn("failed to stat image", "path", path, "error", err)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		s.log.Warn("failed to open image", "path", path, "error", err)
+		return
+	}
+	defer file.Close()
+
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		s.log.Info("saved image of unknown format", "path", path, "bytes", info.Size())
+		return
+	}
+
+	s.log.Info("saved image", "path", path, "format", format, "width", config.Width, "height", config.Height, "bytes", info.Size())
+}
+
+// writeThumbnail writes a JPEG thumbnail of img, the image at imagePath, scaled so
+// that its largest dimension is at most size
+func (s *Spotlight) writeThumbnail(imagePath string, img image.Image, size int) error {
+	width, height := fitWithin(img.Bounds().Dx(), img.Bounds().Dy(), size)
+	thumb := resize(img, width, height)
+
+	thumbPath := imagePath + thumbnailSuffix
+
+	file, err := createFile(thumbPath, s.fileMode)
+	if err != nil {
+		return fmt.Errorf("create thumbnail file: %w", err)
+	}
+	defer file.Close()
+
+	if err := jpeg.Encode(file, thumb, &jpeg.Options{Quality: 85}); err != nil {
+		return fmt.Errorf("encode thumbnail: %w", err)
+	}
+
+	s.log.Info("wrote thumbnail", "path", thumbPath, "width", width, "height", height)
+
+	return nil
+}
+
+// removeThumbnail deletes the thumbnail of the image at imagePath, if any
+func removeThumbnail(imagePath string) error {
+	if err := os.Remove(imagePath + thumbnailSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// moveThumbnail moves the thumbnail of the image at imagePath along with the image
+// moved to dst, if it has one
+func moveThumbnail(imagePath, dst string) error {
+	if err := moveFile(imagePath+thumbnailSuffix, dst+thumbnailSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// fitWithin returns width and height scaled down, keeping the aspect ratio, so that
+// neither exceeds size. Dimensions already within size are returned as-is
+func fitWithin(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+
+	if width >= height {
+		return size, max(1, height*size/width)
+	}
+
+	return max(1, width*size/height), size
+}
+
+// resize scales img to width x height. Each destination pixel is the average of
+// the source pixels it covers, which gives good results when scaling down
+func resize(img image.Image, width, height int) image.Image {
+	src := img.Bounds()
+	dst := image.NewRGBA64(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		y0 := src.Min.Y + y*src.Dy()/height
+		y1 := max(y0+1, src.Min.Y+(y+1)*src.Dy()/height)
+
+		for x := range width {
+			x0 := src.Min.X + x*src.Dx()/width
+			x1 := max(x0+1, src.Min.X+(x+1)*src.Dx()/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
+
+// letterboxColors returns the average color of the edges of img and the average
+// color of the whole image, as hex strings. They are computed on a downscaled copy
+func letterboxColors(img image.Image) (string, string) {
+	width, height := fitWithin(img.Bounds().Dx(), img.Bounds().Dy(), 64)
+	small := resize(img, width, height).(*image.RGBA64)
+
+	var edge, all [3]uint64
+	var edges, n uint64
+	for y := range height {
+		for x := range width {
+			c := small.RGBA64At(x, y)
+			rgb := [3]uint64{uint64(c.R), uint64(c.G), uint64(c.B)}
+
+			for i := range rgb {
+				all[i] += rgb[i]
+			}
+			n++
+
+			if x == 0 || y == 0 || x == width-1 || y == height-1 {
+				for i := range rgb {
+					edge[i] += rgb[i]
+				}
+				edges++
+			}
+		}
+	}
+
+	hex := func(sum [3]uint64, n uint64) string {
+		return fmt.Sprintf("#%02x%02x%02x", sum[0]/n>>8, sum[1]/n>>8, sum[2]/n>>8)
+	}
+
+	return hex(edge, edges), hex(all, n)
+}
+
+// dHash returns the difference hash of img, a perceptual hash that is similar for
+// visually identical images regardless of their resolution or compression. Each
+// bit tells whether a pixel of a 9x8 downscaled copy is brighter than its right
+// neighbour
+func dHash(img image.Image) uint64 {
+	small := resize(img, 9, 8).(*image.RGBA64)
+
+	luma := func(x, y int) float64 {
+		c := small.RGBA64At(x, y)
+		return 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+	}
+
+	var hash uint64
+	for y := range 8 {
+		for x := range 8 {
+			hash <<= 1
+			if luma(x, y) > luma(x+1, y) {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// darkThreshold is the brightness, on a scale of 0 to 255, below which an image is
+// considered dark
+const darkThreshold = 128
+
+// brightness returns the average luminance of img on a scale of 0 to 255, computed
+// on a downscaled copy
+func brightness(img image.Image) float64 {
+	width, height := fitWithin(img.Bounds().Dx(), img.Bounds().Dy(), 256)
+	small := resize(img, width, height).(*image.RGBA64)
+
+	var sum float64
+	for y := range height {
+		for x := range width {
+			c := small.RGBA64At(x, y)
+			// Rec. 601 luma, scaled from 16 to 8 bits
+			sum += (0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)) / 257
+		}
+	}
+
+	return sum / float64(width*height)
+}
+
+// contrast returns the standard deviation of the luminance of img on a scale of 0
+// to 255, computed on a downscaled copy
+func contrast(img image.Image) float64 {
+	width, height := fitWithin(img.Bounds().Dx(), img.Bounds().Dy(), 256)
+	small := resize(img, width, height).(*image.RGBA64)
+
+	var sum, sumSq float64
+	for y := range height {
+		for x := range width {
+			c := small.RGBA64At(x, y)
+			// Rec. 601 luma, scaled from 16 to 8 bits
+			luma := (0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)) / 257
+			sum += luma
+			sumSq += luma * luma
+		}
+	}
+
+	n := float64(width * height)
+	mean := sum / n
+
+	return math.Sqrt(max(0, sumSq/n-mean*mean))
+}
@@ -0,0 +1,66 @@
+package spotlight
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// features are the checks and derivations run on the content of a fetched image
+var features = []func(img image.Image){
+	func(img image.Image) { contrast(img) },
+	func(img image.Image) { dHash(img) },
+	func(img image.Image) { letterboxColors(img) },
+	func(img image.Image) { brightness(img) },
+}
+
+// BenchmarkDecode compares decoding a fetched image once and sharing it between
+// all features with decoding it again for each feature
+func BenchmarkDecode(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "image.jpg")
+
+	img := image.NewRGBA(image.Rect(0, 0, 1920, 1080))
+	for y := range 1080 {
+		for x := range 1920 {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), uint8(x + y), 255})
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := jpeg.Encode(file, img, nil); err != nil {
+		b.Fatal(err)
+	}
+	file.Close()
+
+	b.Run("once", func(b *testing.B) {
+		for range b.N {
+			img, _, err := decodeImage(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			for _, feature := range features {
+				feature(img)
+			}
+		}
+	})
+
+	b.Run("per-feature", func(b *testing.B) {
+		for range b.N {
+			for _, feature := range features {
+				img, _, err := decodeImage(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				feature(img)
+			}
+		}
+	})
+}
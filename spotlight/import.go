@@ -0,0 +1,157 @@
+package spotlight
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Import restores the managed images and index entries from an archive written
+// by Export and read from r. Images whose content or name already exists are
+// skipped. Modification times are preserved. A summary is written to w
+func (s *Spotlight) Import(r io.Reader, w io.Writer) error {
+	files, err := s.managedImages()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("list managed images: %w", err)
+	}
+
+	existing := map[string]bool{}
+	for _, file := range files {
+		sum, err := hashFile(file.path)
+		if err != nil {
+			return fmt.Errorf("hash image: %w", err)
+		}
+		existing[sum] = true
+	}
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("read archive: %w", err)
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+
+	archived := index{}
+	var imported []string
+	var skipped int
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read archive: %w", err)
+		}
+
+		if header.Name == indexFile {
+			if err := json.NewDecoder(tr).Decode(&archived); err != nil {
+				return fmt.Errorf("decode archived index: %w", err)
+			}
+			continue
+		}
+
+		rel, ok := strings.CutPrefix(header.Name, exportImageDir+"/")
+		if !ok || header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// archives may come from elsewhere, entries must not escape the image directory
+		if !filepath.IsLocal(rel) || !s.isManaged(filepath.Base(rel)) {
+			s.log.Warn("skipping archive entry that is not a managed image", "value", header.Name)
+			skipped++
+			continue
+		}
+
+		ok, err = s.importImage(tr, header, filepath.Join(s.dir, rel), existing)
+		if err != nil {
+			return fmt.Errorf("import %s: %w", rel, err)
+		}
+		if !ok {
+			skipped++
+			continue
+		}
+
+		imported = append(imported, filepath.Base(rel))
+	}
+
+	idx, err := s.loadIndex()
+	if err != nil {
+		return fmt.Errorf("load index: %w", err)
+	}
+
+	for _, name := range imported {
+		if entry, ok := archived[name]; ok {
+			idx[name] = entry
+		}
+	}
+
+	if err := s.saveIndex(idx); err != nil {
+		return fmt.Errorf("save index: %w", err)
+	}
+
+	fmt.Fprintf(w, "imported %d images, skipped %d\n", len(imported), skipped)
+
+	return nil
+}
+
+// importImage writes the image read from r, described by header, to path. It
+// reports false if the image was skipped, because an image with the same content
+// in existing or a file at path exists
+func (s *Spotlight) importImage(r io.Reader, header *tar.Header, path string, existing map[string]bool) (bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		s.log.Info("skipping image, file already exists", "path", path)
+		return false, nil
+	}
+
+	if err := mkdirAll(filepath.Dir(path), s.dirMode); err != nil {
+		return false, fmt.Errorf("create image directory: %w", err)
+	}
+
+	tmpPath := path + tmpSuffix
+
+	file, err := createFile(tmpPath, s.fileMode)
+	if err != nil {
+		return false, fmt.Errorf("create image file: %w", err)
+	}
+
+	defer os.Remove(tmpPath)
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), r); err != nil {
+		return false, fmt.Errorf("write image file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return false, fmt.Errorf("close image file: %w", err)
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if existing[sum] {
+		s.log.Info("skipping image, identical image already exists", "path", path)
+		return false, nil
+	}
+
+	if err := os.Chtimes(tmpPath, header.ModTime, header.ModTime); err != nil {
+		return false, fmt.Errorf("set image modification time: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return false, fmt.Errorf("move image into place: %w", err)
+	}
+
+	existing[sum] = true
+
+	s.log.Info("imported image", "path", path)
+
+	return true, nil
+}
@@ -0,0 +1,84 @@
+package spotlight
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestImportEntryNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+		// want is the path the entry is written to relative to the image
+		// directory, or empty if it must be skipped
+		want string
+	}{
+		{"managed image", "images/gnome-spotlight_a.jpg", "gnome-spotlight_a.jpg"},
+		{"per source directory", "images/lockscreen/gnome-spotlight_a.jpg", "lockscreen/gnome-spotlight_a.jpg"},
+		{"unmanaged image", "images/other.jpg", ""},
+		{"parent directory", "images/../gnome-spotlight_a.jpg", ""},
+		{"nested parent directory", "images/lockscreen/../../gnome-spotlight_a.jpg", ""},
+		{"absolute path", "images//tmp/gnome-spotlight_a.jpg", ""},
+		{"outside images", "../gnome-spotlight_a.jpg", ""},
+		{"absolute outside images", "/tmp/gnome-spotlight_a.jpg", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := filepath.Join(root, "backgrounds", "spotlight")
+
+			s := &Spotlight{
+				log:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+				dir:            dir,
+				prefix:         DefaultPrefix,
+				includePattern: DefaultPrefix + "*",
+				stateDir:       filepath.Join(root, "state"),
+				fileMode:       0o644,
+				dirMode:        0o755,
+			}
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			gw := gzip.NewWriter(&buf)
+			tw := tar.NewWriter(gw)
+			content := []byte("image")
+			header := &tar.Header{Name: tt.entry, Mode: 0o644, Size: int64(len(content)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+			if err := tw.WriteHeader(header); err != nil {
+				t.Fatal(err)
+			}
+			tw.Write(content)
+			tw.Close()
+			gw.Close()
+
+			if err := s.Import(&buf, io.Discard); err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+
+			var written []string
+			filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+				if err == nil && !d.IsDir() && filepath.Ext(path) == ".jpg" {
+					rel, _ := filepath.Rel(dir, path)
+					written = append(written, rel)
+				}
+				return nil
+			})
+
+			switch {
+			case tt.want == "" && len(written) > 0:
+				t.Errorf("entry %q was written to %v, want it skipped", tt.entry, written)
+			case tt.want != "" && (len(written) != 1 || written[0] != tt.want):
+				t.Errorf("entry %q was written to %v, want %s", tt.entry, written, tt.want)
+			}
+		})
+	}
+}
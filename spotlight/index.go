@@ -0,0 +1,124 @@
+package spotlight
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"text/tabwriter"
+)
+
+// indexFile is the name of the state file tracking where managed images came from
+const indexFile = "index.json"
+
+// indexEntry is the provenance and attribution of a single managed image
+type indexEntry struct {
+	Source      string `json:"source"`
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Copyright   string `json:"copyright,omitempty"`
+	Description string `json:"description,omitempty"`
+	// ETag identifies the downloaded version of the image on the server
+	ETag string `json:"etag,omitempty"`
+	// PHash is the hex encoded perceptual hash of the image, see dHash
+	PHash string `json:"phash,omitempty"`
+}
+
+// index maps managed image file names to their provenance
+type index map[string]indexEntry
+
+// loadIndex reads the index file, returning an empty index if it doesn't exist
+func (s *Spotlight) loadIndex() (index, error) {
+	data, err := s.readState(indexFile)
+	if err != nil {
+		return nil, fmt.Errorf("read index file: %w", err)
+	}
+
+	idx := index{}
+	if data == nil {
+		return idx, nil
+	}
+
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("decode index file: %w", err)
+	}
+
+	return idx, nil
+}
+
+// saveIndex writes idx to the index file
+func (s *Spotlight) saveIndex(idx index) error {
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode index: %w", err)
+	}
+
+	if err := s.writeState(indexFile, data); err != nil {
+		return fmt.Errorf("write index file: %w", err)
+	}
+
+	return nil
+}
+
+// archiveIndex adds the entries of archived images to the index file in the
+// archive directory, so that their provenance is kept along with them
+func (s *Spotlight) archiveIndex(archived index) error {
+	indexPath := path.Join(s.archiveDir, indexFile)
+
+	idx := index{}
+	data, err := os.ReadFile(indexPath)
+	if err == nil {
+		if err := json.Unmarshal(data, &idx); err != nil {
+			return fmt.Errorf("decode archive index file: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read archive index file: %w", err)
+	}
+
+	maps.Copy(idx, archived)
+
+	data, err = json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode archive index: %w", err)
+	}
+
+	if err := os.WriteFile(indexPath, data, s.fileMode); err != nil {
+		return fmt.Errorf("write archive index file: %w", err)
+	}
+
+	return nil
+}
+
+// List writes the managed images, oldest first, along with their provenance to w
+func (s *Spotlight) List(w io.Writer) error {
+	files, err := s.managedImages()
+	if err != nil {
+		return fmt.Errorf("list managed images: %w", err)
+	}
+
+	idx, err := s.loadIndex()
+	if err != nil {
+		return fmt.Errorf("load index: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tMODIFIED\tSOURCE\tTITLE\tURL")
+	for _, file := range files {
+		entry, ok := idx[file.Name()]
+		if !ok {
+			entry = indexEntry{Source: "-", URL: "-"}
+		}
+
+		title := entry.Title
+		if title == "" {
+			title = "-"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", file.Name(), file.ModTime().Format("2006-01-02 15:04"), entry.Source, title, entry.URL)
+	}
+
+	return tw.Flush()
+}
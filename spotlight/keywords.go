@@ -0,0 +1,37 @@
+package spotlight
+
+import (
+	"strings"
+
+	"github.com/eric-carlsson/gnome-spotlight/api"
+)
+
+// matchKeyword returns the first of keywords contained in any of fields, ignoring
+// case, or an empty string if none is
+func matchKeyword(keywords []string, fields ...string) string {
+	for _, keyword := range keywords {
+		for _, field := range fields {
+			if strings.Contains(strings.ToLower(field), strings.ToLower(keyword)) {
+				return keyword
+			}
+		}
+	}
+
+	return ""
+}
+
+// excludedKeyword returns the exclude keyword matched by the title or copyright of
+// image, or an empty string if none is
+func (s *Spotlight) excludedKeyword(image api.Image) string {
+	return matchKeyword(s.excludeWords, image.Title, image.Copyright)
+}
+
+// included reports whether the title, copyright or description of image contains
+// any of the include keywords. Without include keywords, all images are included
+func (s *Spotlight) included(image api.Image) bool {
+	if len(s.includeWords) == 0 {
+		return true
+	}
+
+	return matchKeyword(s.includeWords, image.Title, image.Copyright, image.Description) != ""
+}
@@ -0,0 +1,40 @@
+package spotlight
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// lastRunFile is the name of the state file recording the time of the last
+// successful run
+const lastRunFile = "last-run"
+
+// lastRun returns the time of the last successful run, or the zero time if there
+// is no record of one
+func (s *Spotlight) lastRun() (time.Time, error) {
+	data, err := s.readState(lastRunFile)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("read last run file: %w", err)
+	}
+
+	if data == nil {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse last run file: %w", err)
+	}
+
+	return t, nil
+}
+
+// recordRun writes t as the time of the last successful run
+func (s *Spotlight) recordRun(t time.Time) error {
+	if err := s.writeState(lastRunFile, []byte(t.Format(time.RFC3339)+"\n")); err != nil {
+		return fmt.Errorf("write last run file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package spotlight
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/eric-carlsson/gnome-spotlight/api"
+)
+
+// ProbeLocale writes the locale environment variables, the locale derived from
+// them and the API request URL of each source to w, to diagnose which region
+// images are requested for
+func (s *Spotlight) ProbeLocale(ctx context.Context, w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	for _, name := range api.LocaleVars() {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			value = "(unset)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", name, value)
+	}
+
+	if s.locale != nil {
+		fmt.Fprintf(tw, "LOCALE\t%s (forced)\n", s.locale.Tag)
+		fmt.Fprintf(tw, "COUNTRY\t%s (forced)\n", s.locale.Country)
+	} else if locale, err := api.ResolveLocale(s.log); err != nil {
+		fmt.Fprintf(tw, "LOCALE\t(error: %s)\n", err)
+	} else {
+		fmt.Fprintf(tw, "LOCALE\t%s\n", locale.Tag)
+		fmt.Fprintf(tw, "COUNTRY\t%s\n", locale.Country)
+	}
+
+	for _, source := range s.sources {
+		urler, ok := source.(api.URLer)
+		if !ok {
+			continue
+		}
+
+		if url, err := urler.RequestURL(ctx); err != nil {
+			fmt.Fprintf(tw, "URL %s\t(error: %s)\n", source.Name(), err)
+		} else {
+			fmt.Fprintf(tw, "URL %s\t%s\n", source.Name(), api.RedactURL(url))
+		}
+	}
+
+	return tw.Flush()
+}
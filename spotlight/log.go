@@ -0,0 +1,88 @@
+package spotlight
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// logBuffer holds log records until it is known whether they should be written
+type logBuffer struct {
+	mu      sync.Mutex
+	entries []bufferedRecord
+}
+
+// bufferedRecord is a log record along with the handler that should write it
+type bufferedRecord struct {
+	handler slog.Handler
+	record  slog.Record
+}
+
+// flush writes the buffered records to their handlers
+func (b *logBuffer) flush(ctx context.Context) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, entry := range b.entries {
+		entry.handler.Handle(ctx, entry.record)
+	}
+
+	b.entries = nil
+}
+
+// logSwitch holds the logBuffer records are currently added to, if any. It is
+// shared by all loggers derived from a Spotlight's, including those of its
+// sources, so that their records can be held back together
+type logSwitch struct {
+	mu  sync.Mutex
+	buf *logBuffer
+}
+
+// set makes records be added to buf, or written again if buf is nil
+func (sw *logSwitch) set(buf *logBuffer) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	sw.buf = buf
+}
+
+// current returns the logBuffer records are added to, or nil if they are written
+func (sw *logSwitch) current() *logBuffer {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	return sw.buf
+}
+
+// bufferHandler is a slog.Handler adding records to the logBuffer currently set
+// in its logSwitch instead of writing them, or writing them if there is none
+type bufferHandler struct {
+	next slog.Handler
+	sw   *logSwitch
+}
+
+func (h *bufferHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *bufferHandler) Handle(ctx context.Context, r slog.Record) error {
+	buf := h.sw.current()
+	if buf == nil {
+		return h.next.Handle(ctx, r)
+	}
+
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	buf.entries = append(buf.entries, bufferedRecord{handler: h.next, record: r.Clone()})
+
+	return nil
+}
+
+func (h *bufferHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &bufferHandler{next: h.next.WithAttrs(attrs), sw: h.sw}
+}
+
+func (h *bufferHandler) WithGroup(name string) slog.Handler {
+	return &bufferHandler{next: h.next.WithGroup(name), sw: h.sw}
+}
@@ -0,0 +1,84 @@
+package spotlight
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+)
+
+// perceptualHash returns the perceptual hash of the managed image file, from the
+// index if recorded there or else by decoding it. Hashes computed are recorded in
+// idx for images it has an entry for
+func (s *Spotlight) perceptualHash(idx index, file managedImage) (uint64, error) {
+	entry, ok := idx[file.Name()]
+	if ok && entry.PHash != "" {
+		if hash, err := strconv.ParseUint(entry.PHash, 16, 64); err == nil {
+			return hash, nil
+		}
+	}
+
+	img, _, err := decodeImage(file.path)
+	if err != nil {
+		return 0, err
+	}
+
+	hash := dHash(img)
+
+	if ok {
+		entry.PHash = formatPHash(hash)
+		idx[file.Name()] = entry
+	}
+
+	return hash, nil
+}
+
+// formatPHash returns the hex encoding of a perceptual hash
+func formatPHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// similar reports whether two perceptual hashes differ in at most the configured
+// number of bits
+func (s *Spotlight) similar(a, b uint64) bool {
+	return bits.OnesCount64(a^b) <= int(s.phashDistance)
+}
+
+// similarImage returns the name of a managed image other than the one named name
+// visually identical to the image with the perceptual hash, or an empty name if
+// there is none
+func (s *Spotlight) similarImage(hash uint64, name string) (string, error) {
+	files, err := s.managedImages()
+	if err != nil {
+		return "", fmt.Errorf("list managed images: %w", err)
+	}
+
+	idx, err := s.loadIndex()
+	if err != nil {
+		return "", fmt.Errorf("load index: %w", err)
+	}
+
+	var similar string
+	for _, file := range files {
+		if file.Name() == name {
+			continue
+		}
+
+		other, err := s.perceptualHash(idx, file)
+		if err != nil {
+			s.log.Warn("failed to hash managed image, skipping", "value", file.Name(), "error", err)
+			continue
+		}
+
+		if s.similar(hash, other) {
+			similar = file.Name()
+			break
+		}
+	}
+
+	// keep the hashes computed along the way
+	if err := s.saveIndex(idx); err != nil {
+		return "", fmt.Errorf("save index: %w", err)
+	}
+
+	return similar, nil
+}
@@ -0,0 +1,105 @@
+package spotlight
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// runsFile is the name of the state file recording the number of runs since the
+// last download, when randomizing
+const runsFile = "runs-since-download"
+
+// randomize applies a random managed image other than the current background
+// instead of downloading a new one, unless a download is due. A download is due
+// every downloadEvery runs, or if there are fewer than minPool managed images to
+// choose from. It reports whether an image was applied
+func (s *Spotlight) randomize(ctx context.Context) (bool, error) {
+	runs, err := s.runsSinceDownload()
+	if err != nil {
+		return false, err
+	}
+
+	if runs+1 >= s.downloadEvery {
+		s.log.Info("download is due", "runs", runs+1, "every", s.downloadEvery)
+		return false, nil
+	}
+
+	files, err := s.managedImages()
+	if err != nil {
+		return false, fmt.Errorf("list managed images: %w", err)
+	}
+
+	if uint(len(files)) < s.minPool {
+		s.log.Info("too few managed images to randomize, downloading", "count", len(files), "min", s.minPool)
+		return false, nil
+	}
+
+	current, err := s.currentImage(ctx)
+	if err != nil {
+		s.log.Warn("failed to get current background, it may be picked again", "error", err)
+	}
+
+	var candidates []string
+	for _, file := range files {
+		if file.path != filepath.Clean(current) {
+			candidates = append(candidates, file.path)
+		}
+	}
+
+	if len(candidates) == 0 {
+		s.log.Info("no managed image other than the current background, downloading")
+		return false, nil
+	}
+
+	var i int
+	if s.rand != nil {
+		i = s.rand.IntN(len(candidates))
+	} else {
+		i = rand.IntN(len(candidates))
+	}
+
+	s.log.Info("applying random managed image", "value", candidates[i], "runs", runs+1, "every", s.downloadEvery)
+
+	if err := s.Apply(ctx, candidates[i]); err != nil {
+		return true, err
+	}
+
+	if err := s.recordRuns(runs + 1); err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
+
+// runsSinceDownload returns the number of runs since the last download, or 0 if
+// there is no record of one
+func (s *Spotlight) runsSinceDownload() (uint, error) {
+	data, err := s.readState(runsFile)
+	if err != nil {
+		return 0, fmt.Errorf("read runs file: %w", err)
+	}
+
+	if data == nil {
+		return 0, nil
+	}
+
+	runs, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("parse runs file: %w", err)
+	}
+
+	return uint(runs), nil
+}
+
+// recordRuns writes runs as the number of runs since the last download
+func (s *Spotlight) recordRuns(runs uint) error {
+	if err := s.writeState(runsFile, []byte(strconv.FormatUint(uint64(runs), 10)+"\n")); err != nil {
+		return fmt.Errorf("write runs file: %w", err)
+	}
+
+	return nil
+}
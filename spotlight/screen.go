@@ -0,0 +1,96 @@
+package spotlight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// mutterPrimaryRe matches the logical monitor marked primary in the state
+	// returned by Mutter, capturing the connector of its first monitor
+	mutterPrimaryRe = regexp.MustCompile(`\(-?\d+, -?\d+, [\d.]+, uint32 \d+, true, \[\('([^']+)'`)
+	// mutterModeRe matches the current mode of a monitor in the state returned by
+	// Mutter, capturing its width and height
+	mutterModeRe = regexp.MustCompile(`\('[^']*', (\d+), (\d+), [\d.]+, [\d.]+, \[[^\]]*\], \{[^}]*'is-current': <true>`)
+	// xrandrRe matches a connected output in the output of xrandr, capturing
+	// whether it is primary and its width and height
+	xrandrRe = regexp.MustCompile(`(?m) connected (primary )?(\d+)x(\d+)\+`)
+)
+
+// detectScreen returns the resolution of the primary monitor as WxH. It is asked
+// from Mutter, falling back to xrandr
+func (s *Spotlight) detectScreen(ctx context.Context) (string, error) {
+	screen, mutterErr := s.mutterScreen(ctx)
+	if mutterErr == nil {
+		return screen, nil
+	}
+
+	s.log.Debug("failed to get screen resolution from mutter, trying xrandr", "error", mutterErr)
+
+	screen, xrandrErr := s.xrandrScreen(ctx)
+	if xrandrErr == nil {
+		return screen, nil
+	}
+
+	return "", errors.Join(mutterErr, xrandrErr)
+}
+
+// mutterScreen returns the resolution of the primary monitor from the display
+// configuration of Mutter
+func (s *Spotlight) mutterScreen(ctx context.Context) (string, error) {
+	if err := s.ensureSessionBus(); err != nil {
+		return "", err
+	}
+
+	out, err := s.command(
+		ctx, "gdbus", "call", "--session",
+		"--dest", "org.gnome.Mutter.DisplayConfig",
+		"--object-path", "/org/gnome/Mutter/DisplayConfig",
+		"--method", "org.gnome.Mutter.DisplayConfig.GetCurrentState",
+	)
+	if err != nil {
+		return "", fmt.Errorf("get mutter display state: %w", err)
+	}
+
+	state := string(out)
+
+	// the modes of the primary monitor follow its connector in the list of
+	// monitors, which precedes the logical monitors
+	if m := mutterPrimaryRe.FindStringSubmatch(state); m != nil {
+		if i := strings.Index(state, "(('"+m[1]+"'"); i != -1 {
+			state = state[i:]
+		}
+	}
+
+	m := mutterModeRe.FindStringSubmatch(state)
+	if m == nil {
+		return "", errors.New("no current mode in mutter display state")
+	}
+
+	return m[1] + "x" + m[2], nil
+}
+
+// xrandrScreen returns the resolution of the primary output from xrandr, or of
+// the first connected output if none is primary
+func (s *Spotlight) xrandrScreen(ctx context.Context) (string, error) {
+	out, err := s.command(ctx, "xrandr", "--current")
+	if err != nil {
+		return "", err
+	}
+
+	matches := xrandrRe.FindAllStringSubmatch(string(out), -1)
+	if len(matches) == 0 {
+		return "", errors.New("no connected output in xrandr output")
+	}
+
+	for _, m := range matches {
+		if m[1] != "" {
+			return m[2] + "x" + m[3], nil
+		}
+	}
+
+	return matches[0][2] + "x" + matches[0][3], nil
+}
@@ -0,0 +1,92 @@
+package spotlight
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// seenFile is the name of the state file recording the URLs of all images ever
+// downloaded, when deduplicating across sessions
+const seenFile = "seen.json"
+
+// seen maps the URLs of downloaded images to the time they were downloaded
+type seen map[string]time.Time
+
+// loadSeen reads the seen file, returning an empty set if it doesn't exist.
+// Entries older than the forget duration are dropped
+func (s *Spotlight) loadSeen() (seen, error) {
+	data, err := s.readState(seenFile)
+	if err != nil {
+		return nil, fmt.Errorf("read seen file: %w", err)
+	}
+
+	urls := seen{}
+	if data == nil {
+		return urls, nil
+	}
+
+	if err := json.Unmarshal(data, &urls); err != nil {
+		return nil, fmt.Errorf("decode seen file: %w", err)
+	}
+
+	if s.forgetAfter > 0 {
+		for url, t := range urls {
+			if time.Since(t) > s.forgetAfter {
+				s.log.Debug("forgetting seen image", "url", url, "downloaded", t)
+				delete(urls, url)
+			}
+		}
+	}
+
+	return urls, nil
+}
+
+// markSeen records url as downloaded now
+func (s *Spotlight) markSeen(url string) error {
+	urls, err := s.loadSeen()
+	if err != nil {
+		return err
+	}
+
+	urls[url] = time.Now()
+
+	data, err := json.MarshalIndent(urls, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode seen urls: %w", err)
+	}
+
+	if err := s.writeState(seenFile, data); err != nil {
+		return fmt.Errorf("write seen file: %w", err)
+	}
+
+	return nil
+}
+
+// seenBefore reports whether the image at url was downloaded before and has since
+// been cleaned up, along with the time it was downloaded
+func (s *Spotlight) seenBefore(url string) (bool, time.Time, error) {
+	urls, err := s.loadSeen()
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	t, ok := urls[url]
+	if !ok {
+		return false, time.Time{}, nil
+	}
+
+	idx, err := s.loadIndex()
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("load index: %w", err)
+	}
+
+	// images still present are handled like any existing image
+	for _, entry := range idx {
+		if entry.URL == url {
+			return false, time.Time{}, nil
+		}
+	}
+
+	return true, t, nil
+}
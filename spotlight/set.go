@@ -0,0 +1,77 @@
+package spotlight
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Set saves the image read from r as a managed image, named by the hash of its
+// content, and applies it. This allows setting images obtained by other means
+func (s *Spotlight) Set(ctx context.Context, r io.Reader) (Image, error) {
+	if err := mkdirAll(s.dir, s.dirMode); err != nil {
+		return Image{}, fmt.Errorf("create image directory: %w", err)
+	}
+
+	file, err := os.CreateTemp(s.dir, s.prefix+"*"+tmpSuffix)
+	if err != nil {
+		return Image{}, fmt.Errorf("create image file: %w", err)
+	}
+
+	tmpPath := file.Name()
+
+	defer os.Remove(tmpPath)
+	defer file.Close()
+
+	if err := file.Chmod(s.fileMode); err != nil {
+		return Image{}, fmt.Errorf("set image permissions: %w", err)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), r); err != nil {
+		return Image{}, fmt.Errorf("write image file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return Image{}, fmt.Errorf("close image file: %w", err)
+	}
+
+	img, format, err := decodeImage(tmpPath)
+	if err != nil {
+		return Image{}, fmt.Errorf("verify image: %w", err)
+	}
+
+	ext, ok := imageExts[format]
+	if !ok {
+		return Image{}, fmt.Errorf("unsupported image format %q", format)
+	}
+
+	path := filepath.Join(s.dir, s.prefix+hex.EncodeToString(hash.Sum(nil))[:16]+ext)
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return Image{}, fmt.Errorf("move image into place: %w", err)
+	}
+
+	s.log.Info("saved image", "path", path, "format", format, "width", img.Bounds().Dx(), "height", img.Bounds().Dy())
+
+	index, err := s.loadIndex()
+	if err != nil {
+		return Image{}, fmt.Errorf("load index: %w", err)
+	}
+
+	index[filepath.Base(path)] = indexEntry{Source: "set"}
+
+	if err := s.saveIndex(index); err != nil {
+		return Image{}, fmt.Errorf("save index: %w", err)
+	}
+
+	if err := s.apply(ctx, decodedImage{path: path, img: img}); err != nil {
+		return Image{}, err
+	}
+
+	return Image{Path: path, Source: "set"}, nil
+}
@@ -0,0 +1,842 @@
+// Package spotlight fetches images from the configured sources into a directory,
+// applies them as the GNOME background and cleans up old images
+package spotlight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/eric-carlsson/gnome-spotlight/api"
+)
+
+// DefaultPrefix is the default prefix prepended to image names. This is used to
+// track what and clean up old images downloaded by the app
+const DefaultPrefix = "gnome-spotlight_"
+
+// ErrImageExists is returned by Fetch if the image offered by the sources was
+// already downloaded and hasn't changed on the server since
+var ErrImageExists = errors.New("image already exists")
+
+// PreserveAll is the value of Options.Preserve keeping all images
+const PreserveAll = ^uint(0)
+
+// MaxBackendRetries is the maximum of Options.BackendRetries. Writing the
+// background is idempotent, but has side effects such as notifying listeners, so
+// it is retried at most once
+const MaxBackendRetries = 1
+
+// Options configure a Spotlight
+type Options struct {
+	// Dir is the directory images are saved to
+	Dir string
+	// Preserve is the number of images kept by Clean, or PreserveAll. For
+	// compatibility, 0 keeps all images as well
+	Preserve uint
+	// Prefix is prepended to the names of managed images. Defaults to DefaultPrefix
+	Prefix string
+	// IncludePattern is the glob pattern of file names considered by Clean.
+	// Defaults to Prefix followed by *
+	IncludePattern string
+	// ExcludePattern is the glob pattern of file names never deleted by Clean
+	ExcludePattern string
+	// Sources are the names of the sources images are fetched from, in order of
+	// preference
+	Sources []string
+	// SourceWeights replace Sources with a random selection. Each run, the source
+	// tried first is picked with a probability proportional to its weight, the
+	// others serve as fallbacks in order of their names
+	SourceWeights map[string]uint
+	// Seed seeds the random selection of sources, of the image applied when
+	// randomizing and of the URL picked from a URL list, making them
+	// deterministic. 0 seeds them randomly
+	Seed uint64
+	// ExcludeKeywords reject images whose title or copyright contains any of them,
+	// ignoring case. The fallback sources are tried instead
+	ExcludeKeywords []string
+	// IncludeKeywords reject images whose title, copyright and description contain
+	// none of them, ignoring case. The fallback sources are tried instead. Exclude
+	// keywords take precedence
+	IncludeKeywords []string
+	// Headers are sent with the requests of AuthSource
+	Headers http.Header
+	// BearerToken is sent with the requests of AuthSource
+	BearerToken string
+	// AuthSource is the only source Headers and BearerToken are sent to, so that
+	// credentials meant for one provider don't leak to the others. Defaults to the
+	// first of Sources
+	AuthSource string
+	// APIParams are added to the query of provider requests
+	APIParams url.Values
+	// Locale overrides the locale derived from the environment, skipping the
+	// derivation and the geo-IP lookup entirely
+	Locale *api.Locale
+	// CountryURL is a geo-IP endpoint the country of provider requests is looked
+	// up at, see api.Options
+	CountryURL string
+	// MaxAPIBodySize limits the size of API response bodies, see api.Options
+	MaxAPIBodySize int64
+	// SourceFile is the file listing image URLs for the urllist source
+	SourceFile string
+	// Proxy is the proxy URL for API requests and image downloads. Defaults to the
+	// proxy environment variables
+	Proxy string
+	// ProxyAPIOnly limits the proxy to API requests
+	ProxyAPIOnly bool
+	// ProxyImageOnly limits the proxy to image downloads
+	ProxyImageOnly bool
+	// BackendRetries is the number of times a failed dconf write is retried, at
+	// most MaxBackendRetries. Requests are never retried
+	BackendRetries uint
+	// DBusTimeout limits how long each dconf, gsettings, gdbus and xrandr call may
+	// take, so that a stuck session bus fails the run instead of blocking it.
+	// Defaults to 15s
+	DBusTimeout time.Duration
+	// ConnectTimeout limits how long establishing a connection may take. Defaults
+	// to 10s
+	ConnectTimeout time.Duration
+	// KeepAlive is the keep-alive period of connections. Defaults to 30s, negative
+	// values disable keep-alives
+	KeepAlive time.Duration
+	// ClientCert and ClientKey are the paths of a PEM encoded certificate and key
+	// presented to servers requesting a client certificate, e.g. inspecting
+	// proxies. Both must be set together
+	ClientCert string
+	ClientKey  string
+	// CACert is the path of a PEM encoded CA certificate trusted in addition to
+	// the system roots
+	CACert string
+	// AllowedHosts are the hosts images may be downloaded from. Nil allows the
+	// hosts the sources serve images from, see api.Hosts. Empty allows all
+	AllowedHosts []string
+	// AllowHTTP allows API requests and image downloads over plain HTTP, which are
+	// logged as a warning. By default, only HTTPS URLs are requested
+	AllowHTTP bool
+	// MinInterval is the minimum time between successful runs
+	MinInterval time.Duration
+	// SlowWarn is the download throughput in MB/s below which a warning is logged
+	SlowWarn float64
+	// ShortWarn keeps images of which fewer bytes were received than the server
+	// advertised with Content-Length, logging a warning as they may be incomplete,
+	// instead of failing
+	ShortWarn bool
+	// Which is the background variant to update, one of light, dark or both.
+	// Defaults to both
+	Which string
+	// HashName names images by the hash of their content instead of their URL, so
+	// that downloading an image already present is detected regardless of its URL
+	HashName bool
+	// OverwriteUnmanaged allows replacing files that are not managed images, such
+	// as files excluded from cleanup or existing files in CopyTo
+	OverwriteUnmanaged bool
+	// DedupeSeen remembers the URLs of all downloaded images, so that images
+	// downloaded before and since cleaned up are skipped in favor of the fallback
+	// sources. Entries are forgotten after ForgetAfter, 0 keeps them forever
+	DedupeSeen  bool
+	ForgetAfter time.Duration
+	// PerceptualDedupe rejects downloaded images visually identical to a managed
+	// image, and makes Dedupe delete visually identical images. Images are
+	// identical if their perceptual hashes differ in at most PHashDistance bits
+	PerceptualDedupe bool
+	PHashDistance    uint
+	// Probe checks resolved images with a HEAD request before downloading them, so
+	// that dead links make the fallback sources be tried
+	Probe bool
+	// PerSourceDir saves images in a subdirectory of Dir named after their source.
+	// Clean applies the preserve threshold to each directory separately
+	PerSourceDir bool
+	// AtomicKeys rolls back the background keys already written if writing one of
+	// them fails, on a best-effort basis
+	AtomicKeys bool
+	// Force replaces an existing image with the same name instead of failing.
+	// Without it, an existing managed image is only replaced if it changed on the
+	// server
+	Force bool
+	// ArchiveDir is where Clean moves images to instead of deleting them, along
+	// with their thumbnails. Their index entries are kept in index.json there
+	ArchiveDir string
+	// NoCleanup disables cleanup in Run
+	NoCleanup bool
+	// CleanupDryRun makes cleanup only log the images it would delete or archive,
+	// without touching them
+	CleanupDryRun bool
+	// QuietOnUnchanged makes Run discard its logs if it neither downloads nor
+	// applies an image. An image that was already downloaded is not an error then
+	QuietOnUnchanged bool
+	// PreClean makes Run also clean up before fetching, making room for the new
+	// image. The image currently set as background is kept
+	PreClean bool
+	// Thumbnail is the size of thumbnails written for new images. 0 disables them
+	Thumbnail uint
+	// NoNetwork forbids all network access. Run applies the image last applied
+	// instead of fetching one, and fails if there is none
+	NoNetwork bool
+	// RandomizeOnStart makes Run apply a random managed image other than the
+	// current background instead of downloading a new one. A new image is still
+	// downloaded every DownloadEvery runs, or if there are fewer than MinPool
+	// managed images
+	RandomizeOnStart bool
+	DownloadEvery    uint
+	MinPool          uint
+	// RefreshIfStale makes Run reapply the last image if the background drifted
+	RefreshIfStale bool
+	// CopyTo is a directory new images are also copied to. Earlier copies are
+	// replaced, other files only with OverwriteUnmanaged
+	CopyTo string
+	// FileMode and DirMode are the permissions of created images and directories.
+	// Default to 0644 and 0755
+	FileMode, DirMode os.FileMode
+	// VerifyDecode rejects downloaded images that fail to decode
+	VerifyDecode bool
+	// MinContrast rejects downloaded images whose luminance standard deviation, on
+	// a scale of 0 to 255, is below it. 0 disables the check
+	MinContrast float64
+	// Transform is a comma separated pipeline of transforms applied to downloaded
+	// images, e.g. resize=1920x1080,blur=8,format=png. Supported transforms are
+	// resize=WxH, fit=WxH and blur=radius, format=jpeg|png converts the result
+	Transform string
+	// FitScreen crops downloaded images to the aspect ratio of the screen and
+	// scales them down to its resolution, before other transforms
+	FitScreen bool
+	// Screen is the resolution of the screen as WxH, e.g. 1920x1080. Defaults to
+	// the resolution of the primary monitor
+	Screen string
+	// LetterboxColors sets the background colors from the image when applying it
+	LetterboxColors bool
+	// ColorScheme switches the interface between the dark and the default color
+	// scheme depending on the brightness of the image when applying it
+	ColorScheme bool
+	// StateDir is the directory for state files and cached API responses.
+	// Defaults to a directory below $XDG_STATE_HOME/gnome-spotlight keyed by Dir
+	// and Prefix
+	StateDir string
+}
+
+// Spotlight manages the GNOME background
+type Spotlight struct {
+	log            *slog.Logger
+	logs           *logSwitch
+	dir            string
+	preserve       uint
+	prefix         string
+	includePattern string
+	excludePattern string
+	imageClient    *http.Client
+	minInterval    time.Duration
+	dbusTimeout    time.Duration
+	backendRetries uint
+	slowWarn       float64
+	shortWarn      bool
+	which          string
+	force          bool
+	atomicKeys     bool
+	overwrite      bool
+	probe          bool
+	dedupeSeen     bool
+	phash          bool
+	phashDistance  uint
+	forgetAfter    time.Duration
+	perSourceDir   bool
+	contentNames   bool
+	archiveDir     string
+	noCleanup      bool
+	cleanDryRun    bool
+	preClean       bool
+	quiet          bool
+	allowedHosts   []string
+	allowHTTP      bool
+	thumbnail      uint
+	refreshIfStale bool
+	noNetwork      bool
+	randomStart    bool
+	downloadEvery  uint
+	minPool        uint
+	copyTo         string
+	sources        []api.API
+	sourceNames    []string
+	locale         *api.Locale
+	weights        []uint
+	excludeWords   []string
+	includeWords   []string
+	rand           *rand.Rand
+	fileMode       os.FileMode
+	dirMode        os.FileMode
+	verifyDecode   bool
+	minContrast    float64
+	transforms     []transform
+	format         string
+	colors         bool
+	colorScheme    bool
+	stateDir       string
+
+	// current is the name of the image last applied, which Clean never deletes
+	current string
+	// sessionBus is the address of the session bus discovered for the commands
+	// run, if DBUS_SESSION_BUS_ADDRESS is unset
+	sessionBus string
+}
+
+// Image is an image fetched into the image directory
+type Image struct {
+	api.Image
+	// Path is where the image is saved
+	Path string
+	// Source is the name of the source the image was fetched from
+	Source string
+}
+
+// New validates opts and returns a Spotlight configured by them
+func New(log *slog.Logger, opts Options) (*Spotlight, error) {
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultPrefix
+	}
+	if opts.Preserve == 0 {
+		opts.Preserve = PreserveAll
+	}
+	if opts.IncludePattern == "" {
+		opts.IncludePattern = opts.Prefix + "*"
+	}
+	if opts.Which == "" {
+		opts.Which = "both"
+	}
+	if opts.FileMode == 0 {
+		opts.FileMode = 0o644
+	}
+	if opts.DirMode == 0 {
+		opts.DirMode = 0o755
+	}
+	if opts.StateDir == "" {
+		opts.StateDir = defaultStateDir(opts.Dir, opts.Prefix)
+	}
+	if opts.DBusTimeout == 0 {
+		opts.DBusTimeout = 15 * time.Second
+	}
+	if opts.ConnectTimeout == 0 {
+		opts.ConnectTimeout = 10 * time.Second
+	}
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = 30 * time.Second
+	}
+	if len(opts.SourceWeights) > 0 {
+		opts.Sources = slices.Sorted(maps.Keys(opts.SourceWeights))
+	}
+	if len(opts.Sources) == 0 {
+		opts.Sources = []string{"microsoft"}
+	}
+	if opts.AuthSource == "" {
+		opts.AuthSource = opts.Sources[0]
+	}
+
+	if opts.BackendRetries > MaxBackendRetries {
+		return nil, fmt.Errorf("invalid backend retries %d, expected at most %d", opts.BackendRetries, MaxBackendRetries)
+	}
+
+	if !slices.Contains([]string{"light", "dark", "both"}, opts.Which) {
+		return nil, fmt.Errorf("invalid variant %q, expected light, dark or both", opts.Which)
+	}
+
+	if err := validatePrefix(opts.Prefix); err != nil {
+		return nil, fmt.Errorf("invalid prefix: %w", err)
+	}
+
+	for _, pattern := range []string{opts.IncludePattern, opts.ExcludePattern} {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	transforms, format, err := parseTransforms(opts.Transform)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transform: %w", err)
+	}
+
+	if opts.AllowedHosts == nil {
+		for _, name := range opts.Sources {
+			opts.AllowedHosts = append(opts.AllowedHosts, api.Hosts(name, api.Options{SourceFile: opts.SourceFile})...)
+		}
+	}
+	opts.AllowedHosts = allowedHosts(opts.AllowedHosts)
+
+	apiClient, imageClient, err := newClients(opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid http configuration: %w", err)
+	}
+
+	// the logs of the sources are held back along with those of s in quiet mode
+	logs := &logSwitch{}
+	log = slog.New(&bufferHandler{next: log.Handler(), sw: logs})
+
+	s := &Spotlight{
+		log:            log,
+		logs:           logs,
+		dir:            opts.Dir,
+		preserve:       opts.Preserve,
+		prefix:         opts.Prefix,
+		includePattern: opts.IncludePattern,
+		excludePattern: opts.ExcludePattern,
+		imageClient:    imageClient,
+		minInterval:    opts.MinInterval,
+		dbusTimeout:    opts.DBusTimeout,
+		allowHTTP:      opts.AllowHTTP,
+		allowedHosts:   opts.AllowedHosts,
+		backendRetries: opts.BackendRetries,
+		slowWarn:       opts.SlowWarn,
+		shortWarn:      opts.ShortWarn,
+		which:          opts.Which,
+		force:          opts.Force,
+		atomicKeys:     opts.AtomicKeys,
+		overwrite:      opts.OverwriteUnmanaged,
+		probe:          opts.Probe,
+		dedupeSeen:     opts.DedupeSeen,
+		phash:          opts.PerceptualDedupe,
+		phashDistance:  opts.PHashDistance,
+		forgetAfter:    opts.ForgetAfter,
+		perSourceDir:   opts.PerSourceDir,
+		contentNames:   opts.HashName,
+		archiveDir:     opts.ArchiveDir,
+		noCleanup:      opts.NoCleanup,
+		cleanDryRun:    opts.CleanupDryRun,
+		preClean:       opts.PreClean,
+		quiet:          opts.QuietOnUnchanged,
+		thumbnail:      opts.Thumbnail,
+		refreshIfStale: opts.RefreshIfStale,
+		noNetwork:      opts.NoNetwork,
+		randomStart:    opts.RandomizeOnStart,
+		downloadEvery:  opts.DownloadEvery,
+		minPool:        opts.MinPool,
+		copyTo:         opts.CopyTo,
+		fileMode:       opts.FileMode,
+		dirMode:        opts.DirMode,
+		verifyDecode:   opts.VerifyDecode,
+		minContrast:    opts.MinContrast,
+		transforms:     transforms,
+		format:         format,
+		colors:         opts.LetterboxColors,
+		colorScheme:    opts.ColorScheme,
+		stateDir:       opts.StateDir,
+		locale:         opts.Locale,
+	}
+
+	if opts.Seed != 0 {
+		s.rand = rand.New(rand.NewPCG(opts.Seed, opts.Seed))
+	}
+
+	if len(opts.SourceWeights) > 0 {
+		var total uint
+		for _, name := range opts.Sources {
+			s.weights = append(s.weights, opts.SourceWeights[name])
+			total += opts.SourceWeights[name]
+		}
+
+		if total == 0 {
+			return nil, errors.New("invalid source weights: at least one weight must be positive")
+		}
+
+		if s.rand == nil {
+			seed := rand.Uint64()
+			s.rand = rand.New(rand.NewPCG(seed, seed))
+		}
+	}
+
+	// the names are needed to find the image directories before the sources exist
+	s.sourceNames = opts.Sources
+
+	apiOptions := api.Options{
+		Headers:     opts.Headers,
+		BearerToken: opts.BearerToken,
+		Client:      apiClient,
+		Params:      opts.APIParams,
+		Locale:      opts.Locale,
+		CountryURL:  opts.CountryURL,
+		MaxBodySize: opts.MaxAPIBodySize,
+		AllowHTTP:   opts.AllowHTTP,
+		SourceFile:  opts.SourceFile,
+		LastURL:     s.lastURL(),
+		Rand:        s.rand,
+		CacheDir:    path.Join(opts.StateDir, "responses"),
+	}
+
+	for _, name := range opts.Sources {
+		apiOptions := apiOptions
+		if name != opts.AuthSource {
+			apiOptions.Headers, apiOptions.BearerToken = nil, ""
+		}
+
+		source, err := api.New(name, log, apiOptions)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source: %w", err)
+		}
+
+		s.sources = append(s.sources, source)
+	}
+
+	if opts.FitScreen {
+		// the screen is only detected once an image is transformed, so that commands
+		// not fetching work without a graphical session
+		if opts.Screen != "" {
+			if _, _, err := parseSize(opts.Screen); err != nil {
+				return nil, fmt.Errorf("invalid screen resolution: %w", err)
+			}
+		}
+
+		s.transforms = append([]transform{s.newFitScreenTransform(opts.Screen)}, s.transforms...)
+	}
+
+	for _, keyword := range opts.ExcludeKeywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			s.excludeWords = append(s.excludeWords, keyword)
+		}
+	}
+
+	for _, keyword := range opts.IncludeKeywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			s.includeWords = append(s.includeWords, keyword)
+		}
+	}
+
+	return s, nil
+}
+
+// validatePrefix checks that prefix is usable as the start of a file name and
+// contains no glob meta characters, as it is used to build the include pattern
+func validatePrefix(prefix string) error {
+	if prefix == "." || prefix == ".." {
+		return errors.New("prefix must not be a relative directory")
+	}
+
+	if i := strings.IndexAny(prefix, "/\\\x00*?["); i != -1 {
+		return fmt.Errorf("prefix contains invalid character %q", prefix[i])
+	}
+
+	return nil
+}
+
+// Run fetches a new image, applies it and cleans up old images, as configured
+func (s *Spotlight) Run(ctx context.Context) error {
+	if !s.quiet {
+		_, err := s.run(ctx)
+		return err
+	}
+
+	// logs are held back until it is known whether anything changed
+	buf := &logBuffer{}
+	s.logs.set(buf)
+
+	changed, err := s.run(ctx)
+
+	s.logs.set(nil)
+
+	if err == nil && !changed {
+		s.log.Debug("nothing changed, suppressed logs of run")
+		return nil
+	}
+
+	buf.flush(ctx)
+
+	return err
+}
+
+// run is Run, additionally reporting whether an image was downloaded or applied
+func (s *Spotlight) run(ctx context.Context) (bool, error) {
+	if !isBackgroundsDir(s.dir) {
+		s.log.Info(
+			"image directory is not a standard backgrounds location, image is applied but won't show in the wallpaper chooser",
+			"dir", s.dir,
+		)
+	}
+
+	if s.refreshIfStale {
+		refreshed, err := s.refresh(ctx)
+		if err != nil {
+			return false, fmt.Errorf("refresh: %w", err)
+		}
+
+		if refreshed {
+			return true, nil
+		}
+	}
+
+	if s.noNetwork {
+		latest, err := s.appliedImage()
+		if err != nil {
+			return false, err
+		}
+
+		if latest == "" {
+			return false, errors.New("network access is disabled and there is no managed image to apply")
+		}
+
+		s.log.Info("network access is disabled, applying last applied image", "value", latest)
+
+		return true, s.Apply(ctx, latest)
+	}
+
+	if s.randomStart {
+		applied, err := s.randomize(ctx)
+		if err != nil {
+			return applied, fmt.Errorf("randomize: %w", err)
+		}
+
+		if applied {
+			return true, nil
+		}
+	}
+
+	if s.minInterval > 0 {
+		last, err := s.lastRun()
+		if err != nil {
+			return false, fmt.Errorf("get last run: %w", err)
+		}
+
+		if next := last.Add(s.minInterval); time.Now().Before(next) {
+			s.log.Info("minimum interval since last run has not elapsed, skipping", "last", last, "next", next)
+			return false, nil
+		}
+	}
+
+	// images removed by pre-clean are a change even if no image is fetched
+	var cleaned int
+	if s.preClean && !s.noCleanup {
+		var err error
+		if cleaned, err = s.preCleanImages(ctx); err != nil {
+			return true, fmt.Errorf("pre-clean images: %w", err)
+		}
+	}
+
+	image, decoded, err := s.fetch(ctx)
+	if errors.Is(err, ErrImageExists) && s.quiet {
+		s.log.Info("image was already downloaded, nothing to do")
+		return cleaned > 0, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("new image: %w", err)
+	}
+
+	if err := s.apply(ctx, decoded); err != nil {
+		return true, err
+	}
+
+	if s.copyTo != "" {
+		if err := s.copyImage(image.Path); err != nil {
+			return true, err
+		}
+	}
+
+	if s.noCleanup {
+		s.log.Debug("cleanup disabled, keeping all images")
+	} else if err := s.Clean(ctx); err != nil {
+		return true, fmt.Errorf("clean images: %w", err)
+	}
+
+	if err := s.recordRun(time.Now()); err != nil {
+		return true, fmt.Errorf("record run: %w", err)
+	}
+
+	if s.randomStart {
+		if err := s.recordRuns(0); err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+// Apply sets the image at path as the background
+func (s *Spotlight) Apply(ctx context.Context, path string) error {
+	return s.apply(ctx, decodedImage{path: path})
+}
+
+// apply is Apply for the image file of decoded, which is only decoded again if its
+// content is needed but wasn't decoded yet
+func (s *Spotlight) apply(ctx context.Context, decoded decodedImage) error {
+	path := decoded.path
+	if err := s.writeToDconf(ctx, path); err != nil {
+		return fmt.Errorf("write to dconf: %w", err)
+	}
+
+	if s.colors || s.colorScheme {
+		img := decoded.img
+		if img == nil {
+			var err error
+			if img, _, err = decodeImage(path); err != nil {
+				return fmt.Errorf("derive colors: %w", err)
+			}
+		}
+
+		if s.colors {
+			primary, secondary := letterboxColors(img)
+			if err := s.writeColors(ctx, primary, secondary); err != nil {
+				return fmt.Errorf("write colors to dconf: %w", err)
+			}
+		}
+
+		if s.colorScheme {
+			if err := s.writeColorScheme(ctx, brightness(img)); err != nil {
+				return fmt.Errorf("write color scheme to dconf: %w", err)
+			}
+		}
+	}
+
+	s.current = filepath.Base(path)
+
+	if err := s.recordApplied(path); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Clean deletes the oldest managed images exceeding the preserve threshold. The
+// image last applied is never deleted, nor is the current background
+func (s *Spotlight) Clean(ctx context.Context) error {
+	current := s.current
+	if current == "" {
+		current = s.keptImage(ctx)
+	}
+
+	_, err := s.cleanImagesIn(s.preserve, current)
+	return err
+}
+
+// keptImage returns the name of the image Clean keeps if nothing was applied by
+// this Spotlight: the current background if it is a managed image, or else the
+// image last applied. The name is empty if neither is known
+func (s *Spotlight) keptImage(ctx context.Context) string {
+	if background, err := s.currentImage(ctx); err != nil {
+		s.log.Warn("failed to get current background, keeping the image last applied", "error", err)
+	} else if s.inImageDir(background) {
+		return filepath.Base(background)
+	}
+
+	applied, err := s.appliedImage()
+	if err != nil {
+		s.log.Warn("failed to get image last applied, it may be deleted", "error", err)
+		return ""
+	}
+	if applied == "" {
+		return ""
+	}
+
+	return filepath.Base(applied)
+}
+
+// Reapply applies the image last applied again, without fetching. This keeps the
+// background in place on desktops that forget it, e.g. after resume
+func (s *Spotlight) Reapply(ctx context.Context) error {
+	latest, err := s.appliedImage()
+	if err != nil {
+		return err
+	}
+
+	if latest == "" {
+		return errors.New("there is no managed image to reapply")
+	}
+
+	s.log.Info("reapplying last applied image", "value", latest)
+
+	return s.Apply(ctx, latest)
+}
+
+// refresh reapplies the image last applied if the background has drifted
+// away from the managed images, e.g. because GNOME reset it. It reports whether
+// the image was reapplied
+func (s *Spotlight) refresh(ctx context.Context) (bool, error) {
+	current, err := s.currentImage(ctx)
+	if err != nil {
+		return false, fmt.Errorf("get current image: %w", err)
+	}
+
+	if s.inImageDir(current) {
+		s.log.Debug("background points at a managed image", "value", current)
+		return false, nil
+	}
+
+	latest, err := s.appliedImage()
+	if err != nil {
+		return false, err
+	}
+
+	if latest == "" {
+		s.log.Info("background drifted but there is no managed image to reapply", "current", current)
+		return false, nil
+	}
+
+	s.log.Info("background drifted, reapplying last applied image", "current", current, "value", latest)
+
+	if err := s.Apply(ctx, latest); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// lastURL returns the URL the image last applied was fetched from, or an empty
+// string if it is unknown. Modification times may be taken from the server, so the
+// most recent managed image isn't necessarily the one fetched last
+func (s *Spotlight) lastURL() string {
+	applied, err := s.appliedImage()
+	if err != nil || applied == "" {
+		return ""
+	}
+
+	idx, err := s.loadIndex()
+	if err != nil {
+		return ""
+	}
+
+	return idx[filepath.Base(applied)].URL
+}
+
+// latestImage returns the path of the most recent managed image, or an empty
+// string if there is none
+func (s *Spotlight) latestImage() (string, error) {
+	files, err := s.managedImages()
+	if err != nil {
+		return "", fmt.Errorf("list managed images: %w", err)
+	}
+
+	if len(files) == 0 {
+		return "", nil
+	}
+
+	return files[len(files)-1].path, nil
+}
+
+// isBackgroundsDir reports whether dir is located under one of the backgrounds
+// directories scanned by the GNOME wallpaper chooser
+func isBackgroundsDir(dir string) bool {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = path.Join(os.Getenv("HOME"), ".local/share")
+	}
+
+	dataDirs := os.Getenv("XDG_DATA_DIRS")
+	if dataDirs == "" {
+		dataDirs = "/usr/local/share:/usr/share"
+	}
+
+	dir = filepath.Clean(dir)
+	for _, base := range append([]string{dataHome}, filepath.SplitList(dataDirs)...) {
+		if base == "" {
+			continue
+		}
+
+		rel, err := filepath.Rel(path.Join(base, "backgrounds"), dir)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,49 @@
+package spotlight
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// defaultStateDir returns the directory state files are kept in by default for
+// images with the given prefix in dir. It is keyed by both, so that instances
+// managing different images don't share state
+func defaultStateDir(dir, prefix string) string {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		stateHome = path.Join(os.Getenv("HOME"), ".local/state")
+	}
+
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	sum := sha256.Sum256([]byte(filepath.Clean(dir) + "\x00" + prefix))
+
+	return path.Join(stateHome, "gnome-spotlight", hex.EncodeToString(sum[:8]))
+}
+
+// readState returns the content of the state file with the given name, or nil if
+// it doesn't exist
+func (s *Spotlight) readState(name string) ([]byte, error) {
+	data, err := os.ReadFile(path.Join(s.stateDir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+// writeState writes data to the state file with the given name, creating the state
+// directory if needed
+func (s *Spotlight) writeState(name string, data []byte) error {
+	if err := mkdirAll(s.stateDir, 0o755); err != nil {
+		return fmt.Errorf("create state dir: %w", err)
+	}
+
+	return os.WriteFile(path.Join(s.stateDir, name), data, 0o644)
+}
@@ -0,0 +1,36 @@
+package spotlight
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Stats writes the number of managed images, the bytes they take up on disk and
+// the modification times of the oldest and newest image to w
+func (s *Spotlight) Stats(w io.Writer) error {
+	files, err := s.managedImages()
+	if err != nil {
+		return fmt.Errorf("list managed images: %w", err)
+	}
+
+	var size int64
+	for _, file := range files {
+		size += file.Size()
+	}
+
+	oldest, newest := "-", "-"
+	if len(files) > 0 {
+		oldest = files[0].ModTime().Format("2006-01-02 15:04")
+		newest = files[len(files)-1].ModTime().Format("2006-01-02 15:04")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "DIR\t%s\n", s.dir)
+	fmt.Fprintf(tw, "IMAGES\t%d\n", len(files))
+	fmt.Fprintf(tw, "BYTES\t%d\n", size)
+	fmt.Fprintf(tw, "OLDEST\t%s\n", oldest)
+	fmt.Fprintf(tw, "NEWEST\t%s\n", newest)
+
+	return tw.Flush()
+}
@@ -0,0 +1,300 @@
+package spotlight
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"github.com/eric-carlsson/gnome-spotlight/api"
+)
+
+// transform is a step of the transform pipeline applied to downloaded images,
+// given the metadata the provider supplied for the image
+type transform func(img image.Image, meta api.Image) (image.Image, error)
+
+// transforms build the transform of the given name from its argument
+var transforms = map[string]func(arg string) (transform, error){
+	"resize": newResizeTransform,
+	"fit":    newFitTransform,
+	"blur":   newBlurTransform,
+}
+
+// formatExts are the file extensions of the formats images can be converted to
+var formatExts = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+}
+
+// parseTransforms parses a comma separated list of transforms, each in the form
+// name=arg. The format entry is not a transform but selects the format the result
+// is written in, it is returned separately
+func parseTransforms(spec string) ([]transform, string, error) {
+	var steps []transform
+	var format string
+
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		name, arg, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, "", fmt.Errorf("expected name=value, got %q", entry)
+		}
+
+		if name == "format" {
+			if arg == "jpg" {
+				arg = "jpeg"
+			}
+			if _, ok := formatExts[arg]; !ok {
+				return nil, "", fmt.Errorf("unsupported format %q, expected jpeg or png", arg)
+			}
+
+			format = arg
+			continue
+		}
+
+		newTransform, ok := transforms[name]
+		if !ok {
+			return nil, "", fmt.Errorf("unknown transform %q", name)
+		}
+
+		step, err := newTransform(arg)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", name, err)
+		}
+
+		steps = append(steps, step)
+	}
+
+	return steps, format, nil
+}
+
+// fallbackFormat is the format transformed images are written in if neither a
+// format is configured nor the original format can be encoded, e.g. for WebP
+const fallbackFormat = "jpeg"
+
+// transformImage runs the transform pipeline on img, the image at path decoded
+// from format with the metadata meta, and writes the result back to path in the configured format, else
+// the original one if it can be encoded or else the fallback format. It returns
+// the transformed image and the format it was written in
+func (s *Spotlight) transformImage(path string, img image.Image, format string, meta api.Image) (image.Image, string, error) {
+	var err error
+	for _, step := range s.transforms {
+		if img, err = step(img, meta); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if s.format != "" {
+		format = s.format
+	} else if _, ok := formatExts[format]; !ok {
+		s.log.Debug("cannot encode original format, using fallback", "format", format, "fallback", fallbackFormat)
+		format = fallbackFormat
+	}
+
+	out, err := createFile(path, s.fileMode)
+	if err != nil {
+		return nil, "", fmt.Errorf("create image file: %w", err)
+	}
+	defer out.Close()
+
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(out, img, &jpeg.Options{Quality: 92})
+	case "png":
+		err = png.Encode(out, img)
+	default:
+		err = fmt.Errorf("cannot encode format %q", format)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("encode image: %w", err)
+	}
+
+	if err := out.Close(); err != nil {
+		return nil, "", fmt.Errorf("close image file: %w", err)
+	}
+
+	s.log.Info("transformed image", "steps", len(s.transforms), "format", format, "width", img.Bounds().Dx(), "height", img.Bounds().Dy())
+
+	return img, format, nil
+}
+
+// parseSize parses a size given as WxH
+func parseSize(arg string) (int, int, error) {
+	w, h, ok := strings.Cut(arg, "x")
+	width, werr := strconv.Atoi(w)
+	height, herr := strconv.Atoi(h)
+	if !ok || werr != nil || herr != nil || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("expected size as WxH, got %q", arg)
+	}
+
+	return width, height, nil
+}
+
+// newResizeTransform returns a transform scaling images to the size given as WxH
+func newResizeTransform(arg string) (transform, error) {
+	width, height, err := parseSize(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(img image.Image, _ api.Image) (image.Image, error) {
+		return resize(img, width, height), nil
+	}, nil
+}
+
+// newFitTransform returns a transform cropping images to the aspect ratio of the
+// size given as WxH, see fit
+func newFitTransform(arg string) (transform, error) {
+	width, height, err := parseSize(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(img image.Image, _ api.Image) (image.Image, error) {
+		return fit(img, width, height), nil
+	}, nil
+}
+
+// newFitScreenTransform returns a transform like the fit transform for the screen
+// size given as WxH, which crops around the focal point of the image if the
+// provider supplied one. Without a size, the screen is detected the first time
+// the transform runs
+func (s *Spotlight) newFitScreenTransform(screen string) transform {
+	var width, height int
+	return func(img image.Image, meta api.Image) (image.Image, error) {
+		if width == 0 {
+			var err error
+			if screen == "" {
+				if screen, err = s.detectScreen(context.Background()); err != nil {
+					return nil, fmt.Errorf("detect screen resolution, set it explicitly instead: %w", err)
+				}
+
+				s.log.Info("detected screen resolution", "value", screen)
+			}
+
+			if width, height, err = parseSize(screen); err != nil {
+				return nil, fmt.Errorf("invalid screen resolution: %w", err)
+			}
+		}
+
+		if !meta.HasFocus {
+			return fit(img, width, height), nil
+		}
+
+		s.log.Debug("cropping around focal point", "x", meta.FocusX, "y", meta.FocusY)
+
+		return fitAround(img, width, height, meta.FocusX, meta.FocusY), nil
+	}
+}
+
+// fit crops img around its center to the aspect ratio of width x height, and
+// scales it down to that size if it is larger. Images are never scaled up
+func fit(img image.Image, width, height int) image.Image {
+	return fitAround(img, width, height, 0.5, 0.5)
+}
+
+// fitAround is like fit, but centers the crop on the point at fractions fx, fy of
+// the image dimensions instead, as far as the crop stays within the image
+func fitAround(img image.Image, width, height int, fx, fy float64) image.Image {
+	b := img.Bounds()
+
+	// the largest rectangle of the target aspect ratio within the image
+	cropWidth, cropHeight := b.Dx(), b.Dx()*height/width
+	if cropHeight > b.Dy() {
+		cropWidth, cropHeight = b.Dy()*width/height, b.Dy()
+	}
+
+	x0 := b.Min.X + min(max(int(fx*float64(b.Dx()))-cropWidth/2, 0), b.Dx()-cropWidth)
+	y0 := b.Min.Y + min(max(int(fy*float64(b.Dy()))-cropHeight/2, 0), b.Dy()-cropHeight)
+	crop := image.Rect(x0, y0, x0+cropWidth, y0+cropHeight)
+
+	if cropWidth > width {
+		return resize(subImage(img, crop), width, height)
+	}
+
+	return resize(subImage(img, crop), cropWidth, cropHeight)
+}
+
+// subImage returns the part of img within r, sharing pixels with img if possible
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(r)
+	}
+
+	dst := image.NewRGBA64(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			dst.Set(x, y, img.At(x, y))
+		}
+	}
+
+	return dst
+}
+
+// newBlurTransform returns a transform applying a box blur of the given radius
+func newBlurTransform(arg string) (transform, error) {
+	radius, err := strconv.Atoi(arg)
+	if err != nil || radius <= 0 {
+		return nil, fmt.Errorf("expected positive radius, got %q", arg)
+	}
+
+	return func(img image.Image, _ api.Image) (image.Image, error) {
+		return blur(img, radius), nil
+	}, nil
+}
+
+// blur applies a box blur of the given radius to img, as a horizontal pass
+// followed by a vertical one
+func blur(img image.Image, radius int) image.Image {
+	b := img.Bounds()
+	src := image.NewRGBA64(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := range b.Dy() {
+		for x := range b.Dx() {
+			src.Set(x, y, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+
+	dst := image.NewRGBA64(src.Bounds())
+	blurPass(src, dst, radius, 1, 0)
+	blurPass(dst, src, radius, 0, 1)
+
+	return src
+}
+
+// blurPass averages each pixel of src with its neighbours within radius along the
+// direction dx, dy and writes the result to dst
+func blurPass(src, dst *image.RGBA64, radius, dx, dy int) {
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+
+	for y := range height {
+		for x := range width {
+			var r, g, b, a, n uint64
+			for i := -radius; i <= radius; i++ {
+				sx, sy := x+i*dx, y+i*dy
+				if sx < 0 || sy < 0 || sx >= width || sy >= height {
+					continue
+				}
+
+				c := src.RGBA64At(sx, sy)
+				r, g, b, a = r+uint64(c.R), g+uint64(c.G), b+uint64(c.B), a+uint64(c.A)
+				n++
+			}
+
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// minUserID is the lowest uid of regular users. Users below it, such as the display
+// manager, are skipped when managing all users
+const minUserID = 1000
+
+// switchUser drops the privileges of the process to the user identified by name or
+// uid, and points the environment at the user's home, runtime directory and
+// session bus. This allows a system service running as root to manage the
+// background of a user session. The home directory of the user is returned
+func switchUser(name string) (string, error) {
+	if os.Geteuid() != 0 {
+		return "", errors.New("switching user requires running as root")
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		var unknownErr user.UnknownUserError
+		if !errors.As(err, &unknownErr) {
+			return "", fmt.Errorf("look up user: %w", err)
+		}
+
+		if u, err = user.LookupId(name); err != nil {
+			return "", fmt.Errorf("look up user: %w", err)
+		}
+	}
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return "", fmt.Errorf("parse uid: %w", err)
+	}
+
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return "", fmt.Errorf("parse gid: %w", err)
+	}
+
+	var groups []int
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return "", fmt.Errorf("look up groups: %w", err)
+	}
+	for _, id := range groupIDs {
+		if g, err := strconv.Atoi(id); err == nil {
+			groups = append(groups, g)
+		}
+	}
+
+	// order matters, the uid must be changed last as it drops the privilege to
+	// change the others
+	if err := syscall.Setgroups(groups); err != nil {
+		return "", fmt.Errorf("set groups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return "", fmt.Errorf("set gid: %w", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return "", fmt.Errorf("set uid: %w", err)
+	}
+
+	runtimeDir := fmt.Sprintf("/run/user/%d", uid)
+
+	env := map[string]string{
+		"HOME":                     u.HomeDir,
+		"USER":                     u.Username,
+		"LOGNAME":                  u.Username,
+		"XDG_RUNTIME_DIR":          runtimeDir,
+		"DBUS_SESSION_BUS_ADDRESS": "unix:path=" + path.Join(runtimeDir, "bus"),
+	}
+	for key, value := range env {
+		if err := os.Setenv(key, value); err != nil {
+			return "", fmt.Errorf("set %s: %w", key, err)
+		}
+	}
+
+	// these belong to the invoking user and would point at the wrong directories
+	for _, key := range []string{"XDG_DATA_HOME", "XDG_CONFIG_HOME", "XDG_STATE_HOME", "XDG_CACHE_HOME"} {
+		os.Unsetenv(key)
+	}
+
+	return u.HomeDir, nil
+}
+
+// sessionUsers returns the names of the regular users with a session, as known to
+// logind
+func sessionUsers() ([]string, error) {
+	out, err := exec.Command("loginctl", "list-users", "--no-legend").Output()
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+
+	var users []string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		if uid, err := strconv.Atoi(fields[0]); err != nil || uid < minUserID {
+			continue
+		}
+
+		users = append(users, fields[1])
+	}
+
+	return users, nil
+}
+
+// runForUsers runs the executable once for each of users with the given
+// arguments, adding --user so that each run manages the background of one user.
+// Failures are logged per user and joined into the returned error
+func runForUsers(ctx context.Context, log *slog.Logger, users []string, args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("find executable: %w", err)
+	}
+
+	var errs []error
+	for _, name := range users {
+		cmd := exec.CommandContext(ctx, executable, append([]string{"--user", name}, args...)...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+
+		if err := cmd.Run(); err != nil {
+			log.Error("failed to manage background of user", "user", name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+
+		log.Info("managed background of user", "user", name)
+	}
+
+	return errors.Join(errs...)
+}